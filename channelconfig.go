@@ -0,0 +1,105 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"mellium.im/communique/internal/client"
+	"mellium.im/communique/internal/ui"
+	"mellium.im/communique/internal/ui/event"
+	"mellium.im/xmpp/bookmarks"
+	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// createChannel checks that a group chat does not exist yet and then joins it,
+// which creates it.
+// Once it has been created the server sends a status code that triggers the
+// ChannelCreated event and the user is asked how to configure it.
+func createChannel(room jid.JID, c *client.Client, pane *ui.UI, logger *log.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout())
+	defer cancel()
+
+	p := c.Printer()
+	// Don't use the disco cache here since the group chat may have been
+	// destroyed since we last looked it up.
+	_, err := disco.GetInfo(ctx, "", room, c.Session)
+	switch {
+	case err == nil:
+		pane.ShowError(p.Sprintf("The channel %s already exists.", room))
+		return
+	case errors.Is(err, stanza.Error{Condition: stanza.ItemNotFound}):
+	default:
+		// Some services don't respond to queries for group chats that don't exist,
+		// so try joining anyways and let the join report any errors.
+		logger.Print(p.Sprintf("error checking if channel %s exists: %v", room, err))
+	}
+	channel := bookmarks.Channel{
+		JID: room,
+	}
+	pane.UpdateBookmarks(channel)
+	pane.JoinChannel(channel)
+}
+
+// configureChannel fetches the configuration form of a group chat, shows it,
+// and submits it.
+func configureChannel(e event.ConfigureChannel, c *client.Client, pane *ui.UI, logger *log.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout())
+	defer cancel()
+
+	p := c.Printer()
+	formData, err := c.ChannelConfig(ctx, e.JID)
+	if err != nil {
+		logger.Print(p.Sprintf("error fetching configuration of channel %s: %v", e.JID, err))
+		pane.ShowError(p.Sprintf("Could not configure the channel: %v", err))
+		return
+	}
+
+	var (
+		saveBtn   = p.Sprintf("Save")
+		cancelBtn = p.Sprintf("Cancel")
+	)
+	onDone := func(label string) {
+		pane.SelectRoster()
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), c.Timeout())
+			defer cancel()
+			var err error
+			switch {
+			case label == saveBtn:
+				err = c.ConfigureChannel(ctx, e.JID, formData)
+			case e.Created:
+				// Cancelling the configuration of a group chat that we just created
+				// destroys it.
+				err = c.CancelChannelConfig(ctx, e.JID)
+			default:
+				return
+			}
+			if err != nil {
+				logger.Print(p.Sprintf("error configuring channel %s: %v", e.JID, err))
+				pane.ShowError(p.Sprintf("Could not configure the channel: %v", err))
+			}
+		}()
+	}
+	pane.ShowForm(formData, p.Sprintf("Channel Configuration"), []string{saveBtn, cancelBtn}, onDone)
+}
+
+// acceptChannelDefaults unlocks a group chat that we just created with the
+// default configuration.
+func acceptChannelDefaults(room jid.JID, c *client.Client, pane *ui.UI, logger *log.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout())
+	defer cancel()
+
+	p := c.Printer()
+	err := c.ConfigureChannel(ctx, room, nil)
+	if err != nil {
+		logger.Print(p.Sprintf("error configuring channel %s: %v", room, err))
+		pane.ShowError(p.Sprintf("Could not configure the channel: %v", err))
+	}
+}
@@ -7,6 +7,7 @@ package main
 import (
 	"context"
 	"log"
+	"sync"
 	"time"
 
 	/* #nosec */
@@ -23,11 +24,12 @@ import (
 	"mellium.im/xmpp/history"
 	"mellium.im/xmpp/jid"
 	"mellium.im/xmpp/roster"
+	"mellium.im/xmpp/stanza"
 )
 
 // newClientHandler returns a handler for events that are emitted by the client
 // that need to modify the UI.
-func newClientHandler(client *client.Client, pane *ui.UI, db *storage.DB, logger, debug *log.Logger) func(interface{}) {
+func newClientHandler(client *client.Client, pane *ui.UI, db *storage.DB, historySync *historySync, logger, debug *log.Logger) func(interface{}) {
 	p := client.Printer()
 	return func(ev interface{}) {
 		defer panicHandler()
@@ -39,7 +41,14 @@ func newClientHandler(client *client.Client, pane *ui.UI, db *storage.DB, logger
 		case event.StatusOnline:
 			pane.Online(jid.JID(e), jid.JID(e).Equal(client.LocalAddr()))
 		case event.StatusOffline:
-			pane.Offline(jid.JID(e), jid.JID(e).Equal(client.LocalAddr()))
+			self := jid.JID(e).Equal(client.LocalAddr())
+			if self {
+				go historySync.Cancel()
+				pane.ClearOccupants(jid.JID{})
+			}
+			pane.Offline(jid.JID(e), self)
+		case event.Reconnecting:
+			pane.Reconnecting(time.Duration(e))
 		case event.FetchBookmarks:
 			for bookmark := range e.Items {
 				pane.UpdateBookmarks(bookmarks.Channel(bookmark))
@@ -64,59 +73,68 @@ func newClientHandler(client *client.Client, pane *ui.UI, db *storage.DB, logger
 				logger.Print(p.Sprintf("error querying database for last seen messages: %v", err))
 				return
 			}
+			extended := sync.OnceValue(func() bool {
+				_, extended := archiveFeatures(client, accountBare, debug)
+				return extended
+			})
+			var open jid.JID
+			if pane.ChatsOpen() {
+				open = pane.GetRosterJID().Bare()
+			}
+			var jobs []syncJob
 			err = db.ForRoster(ctx, func(item event.UpdateRoster) {
 				pane.UpdateRoster(ui.RosterItem{Item: roster.Item(item.Item)})
 				id, ok := ids[item.JID.Bare().String()]
-				go func() {
-					// We don't really care how long it takes to get history, and it will
-					// continue to be processed even if we time out, so just set this to a
-					// long time.
-					ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
-					defer cancel()
-					if ok {
-						// We have some history already, catch up from the last known
-						// message if extended queries are supported, or from the last known
-						// datetime if not.
-
-						// if extended {
-						// 	_, err := history.Fetch(ctx, history.Query{
-						// 		With:    item.JID.Bare(),
-						// 		AfterID: id.ID,
-						// 	}, accountBare, client.Session)
-						// 	if err != nil {
-						// 		logger.Printf("error fetching history after %s for %s: %v", id.ID, item.JID, err)
-						// 	}
-						// 	return
-						// }
+				jobs = append(jobs, syncJob{
+					JID:        item.JID.Bare(),
+					LastActive: id.Delay,
+					Fetch: func(ctx context.Context) {
+						// We don't really care how long it takes to get history, and it
+						// will continue to be processed even if we time out, so just set
+						// this to a long time.
+						ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+						defer cancel()
+						if ok {
+							// We have some history already, catch up from the last known
+							// message if extended queries are supported, or from the last
+							// known datetime if not.
+							q := history.Query{
+								With:  item.JID.Bare(),
+								Start: id.Delay,
+							}
+							if id.ID != "" && extended() {
+								q = history.Query{
+									With:    item.JID.Bare(),
+									AfterID: id.ID,
+								}
+							}
+							_, err := history.Fetch(ctx, q, accountBare, client.Session)
+							if err != nil {
+								logger.Print(p.Sprintf("error fetching history after %s for %s: %v", id.ID, item.JID, err))
+							}
+							return
+						}
 
+						// We don't have any history yet, so bootstrap a limited amount of
+						// history from the server.
+						_, _, _, screenHeight := pane.GetRect()
 						_, err := history.Fetch(ctx, history.Query{
-							With:  item.JID.Bare(),
-							Start: id.Delay,
+							With:    item.JID.Bare(),
+							End:     time.Now(),
+							Limit:   uint64(2 * screenHeight), // #nosec G115
+							Reverse: true,
+							Last:    true,
 						}, accountBare, client.Session)
 						if err != nil {
-							logger.Print(p.Sprintf("error fetching history after %s for %s: %v", id.ID, item.JID, err))
+							debug.Print(p.Sprintf("error bootstraping history for %s: %v", item.JID, err))
 						}
-						return
-					}
-
-					// We don't have any history yet, so bootstrap a limited amount of
-					// history from the server.
-					_, _, _, screenHeight := pane.GetRect()
-					_, err := history.Fetch(ctx, history.Query{
-						With:    item.JID.Bare(),
-						End:     time.Now(),
-						Limit:   uint64(2 * screenHeight), // #nosec G115
-						Reverse: true,
-						Last:    true,
-					}, accountBare, client.Session)
-					if err != nil {
-						debug.Print(p.Sprintf("error bootstraping history for %s: %v", item.JID, err))
-					}
-				}()
+					},
+				})
 			})
 			if err != nil {
 				logger.Print(p.Sprintf("error iterating over roster items: %v", err))
 			}
+			historySync.Start(jobs, open)
 		case event.UpdateRoster:
 			pane.UpdateRoster(ui.RosterItem{Item: e.Item})
 			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -132,9 +150,40 @@ func newClientHandler(client *client.Client, pane *ui.UI, db *storage.DB, logger
 			if err != nil {
 				logger.Print(p.Sprintf("error marking message %q as received: %v", e, err))
 			}
+			pane.SetReceipt(string(e), ui.ReceiptDelivered)
+		case event.MessageFailed:
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			logger.Print(p.Sprintf("error sending message %q to %s: %v", e.ID, e.From, e.Err))
+			err := db.MarkFailed(ctx, e.ID)
+			if err != nil {
+				logger.Print(p.Sprintf("error marking message %q as failed: %v", e.ID, err))
+			}
+			pane.SetReceipt(e.ID, ui.ReceiptFailed)
 		case event.ChatMessage:
 			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 			defer cancel()
+			if e.Retract.ID != "" {
+				// Retractions are never shown as messages, even if they can't be
+				// applied, because they only contain a fallback body.
+				if _, err := applyRetraction(ctx, pane, db, e, client.LocalAddr(), logger); err != nil {
+					logger.Print(p.Sprintf("error retracting message: %v", err))
+				}
+				return
+			}
+			e = withQuote(ctx, pane, db, e, client.LocalAddr(), logger)
+			if e.Replace.ID != "" {
+				ok, err := correctMessage(ctx, pane, db, e, client.LocalAddr(), logger)
+				if err != nil {
+					logger.Print(p.Sprintf("error correcting message: %v", err))
+				}
+				if ok {
+					if e.Sent {
+						pane.SetLastSent(e.With(), e.Replace.ID, displayBody(e))
+					}
+					return
+				}
+			}
 			if err := writeMessage(pane, e, false); err != nil {
 				logger.Print(p.Sprintf("error writing received message to chat: %v", err))
 			}
@@ -144,14 +193,115 @@ func newClientHandler(client *client.Client, pane *ui.UI, db *storage.DB, logger
 			// If we sent the message that wasn't automated (it has a body), assume
 			// we've read everything before it.
 			if e.Sent && e.Body != "" {
-				pane.MarkRead(e.To.Bare().String())
+				markRead(ctx, pane, db, e.With(), logger)
+				if e.Type != stanza.GroupChatMessage {
+					pane.SetLastSent(e.With(), e.ID, displayBody(e))
+				}
 			}
 			if !e.Sent {
-				pane.Notify()
+				// Not everyone sends chat states along with their messages, so make
+				// sure we stop showing them as typing once a message arrives.
+				if e.Body != "" {
+					pane.ChatState(e.From, event.StateActive)
+				}
+				mentioned := pane.Mentions(e.With(), e.Body)
+				if pane.ShouldNotify(e.With(), e.Type == stanza.GroupChatMessage, mentioned) {
+					pane.Notify()
+				}
+			}
+		case event.ChatState:
+			pane.ChatState(e.From, e.State)
+		case event.Invitation:
+			pane.ShowInvitation(ui.Invitation{
+				Room:     e.Room,
+				From:     e.From,
+				Reason:   e.Reason,
+				Password: e.Password,
+				Direct:   e.Direct,
+			})
+			pane.Notify()
+		case event.ChannelCreated:
+			pane.ShowChannelCreated(jid.JID(e))
+		case event.ChannelDisconnected:
+			pane.ClearOccupants(jid.JID(e))
+			pane.ChannelDisconnected(jid.JID(e), true)
+		case event.ChannelRejoined:
+			room := jid.JID(e)
+			pane.ChannelDisconnected(room, false)
+			go func() {
+				archived, extended := archiveFeatures(client, room, debug)
+				if archived {
+					fetchRoomHistory(room, extended, client, pane, db, debug, logger)
+				}
+			}()
+		case event.Occupant:
+			switch {
+			case e.Left && e.Nick != "":
+				// The occupant changed their nickname and will be added again with
+				// their new one.
+				pane.RemoveOccupant(e.Addr)
+			case e.Left && e.Self:
+				pane.ClearOccupants(e.Addr.Bare())
+			case e.Left:
+				pane.RemoveOccupant(e.Addr)
+			default:
+				pane.UpdateOccupant(ui.Occupant{
+					JID:         e.Addr,
+					RealJID:     e.JID,
+					Affiliation: e.Affiliation,
+					Role:        e.Role,
+					Show:        e.Show,
+					Self:        e.Self,
+				})
+			}
+		case event.ChatMarker:
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := applyMarker(ctx, pane, db, event.ChatMessage(e), client.LocalAddr(), logger); err != nil {
+				logger.Print(p.Sprintf("error saving chat marker: %v", err))
+			}
+		case event.Reactions:
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if _, err := reactMessage(ctx, pane, db, event.ChatMessage(e), client.LocalAddr(), logger); err != nil {
+				logger.Print(p.Sprintf("error saving reactions: %v", err))
 			}
 		case event.HistoryMessage:
 			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 			defer cancel()
+			// The time that the message was sent is on the forwarded wrapper, not on
+			// the message itself.
+			if e.Result.Forward.Msg.Delay.Time.IsZero() {
+				e.Result.Forward.Msg.Delay = e.Result.Forward.Delay
+			}
+			if msg := e.Result.Forward.Msg; msg.Retract.ID != "" {
+				if _, err := applyRetraction(ctx, pane, db, msg, client.LocalAddr(), logger); err != nil {
+					logger.Print(p.Sprintf("error retracting message: %v", err))
+				}
+				return
+			}
+			if msg := e.Result.Forward.Msg; msg.Reactions.ID != "" {
+				if _, err := reactMessage(ctx, pane, db, msg, client.LocalAddr(), logger); err != nil {
+					logger.Print(p.Sprintf("error saving reactions: %v", err))
+				}
+				return
+			}
+			if msg := e.Result.Forward.Msg; msg.Marker.ID != "" {
+				if err := applyMarker(ctx, pane, db, msg, client.LocalAddr(), logger); err != nil {
+					logger.Print(p.Sprintf("error saving chat marker: %v", err))
+				}
+				return
+			}
+			e.Result.Forward.Msg = withQuote(ctx, pane, db, e.Result.Forward.Msg, client.LocalAddr(), logger)
+			if msg := e.Result.Forward.Msg; msg.Replace.ID != "" {
+				ok, err := correctMessage(ctx, pane, db, msg, client.LocalAddr(), logger)
+				if err != nil {
+					logger.Print(p.Sprintf("error correcting message: %v", err))
+				}
+				if ok {
+					return
+				}
+			}
 			if err := writeMessage(pane, e.Result.Forward.Msg, false); err != nil {
 				logger.Print(p.Sprintf("error writing history message to chat: %v", err))
 			}
@@ -228,3 +378,24 @@ func newFeatures(e event.NewFeatures, client *client.Client, db *storage.DB, deb
 	result.Info = discoInfo
 	e.Info <- result
 }
+
+// archiveFeatures reports whether j has an archive and whether it supports
+// querying by archive ID (so that we can page through it without relying on
+// timestamps).
+func archiveFeatures(c *client.Client, j jid.JID, debug *log.Logger) (archived, extended bool) {
+	info, err := c.Disco(j)
+	if err != nil {
+		p := c.Printer()
+		debug.Print(p.Sprintf("error discovering archive support for %s: %v", j, err))
+		return false, false
+	}
+	for _, feature := range info.Features {
+		switch feature.Var {
+		case history.NS:
+			archived = true
+		case history.NSExt:
+			extended = true
+		}
+	}
+	return archived, extended
+}
@@ -126,11 +126,15 @@ type config struct {
 	} `toml:"log"`
 
 	UI struct {
-		HideStatus bool     `toml:"hide_status"`
-		Theme      string   `toml:"theme"`
-		Width      int      `toml:"width"`
-		FilePicker []string `toml:"file_picker"`
-		Notify     []string `toml:"notify"`
+		HideStatus        bool     `toml:"hide_status"`
+		Theme             string   `toml:"theme"`
+		Width             int      `toml:"width"`
+		FilePicker        []string `toml:"file_picker"`
+		Notify            []string `toml:"notify"`
+		HighlightWords    []string `toml:"highlight_words"`
+		HighlightPatterns []string `toml:"highlight_patterns"`
+		DisableChatStates bool     `toml:"disable_chat_states"`
+		TimeFormat        string   `toml:"time_format"`
 	} `toml:"ui"`
 
 	Theme []theme `toml:"theme"`
@@ -0,0 +1,130 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/communique/internal/storage"
+	"mellium.im/xmpp/jid"
+)
+
+// delayedMsg returns a message from j that was sent at the given time.
+func delayedMsg(j, id string, delay int64) event.ChatMessage {
+	msg := chatMsg(id, id)
+	msg.From = jid.MustParse(j)
+	msg.Delay.Time = time.Unix(delay, 0)
+	return msg
+}
+
+// conversations returns the recent conversations list.
+func conversations(t *testing.T, db *storage.DB) []storage.Conversation {
+	t.Helper()
+	var convs []storage.Conversation
+	err := db.ForConversations(context.Background(), func(c storage.Conversation) {
+		convs = append(convs, c)
+	})
+	if err != nil {
+		t.Fatalf("error listing conversations: %v", err)
+	}
+	return convs
+}
+
+func convEqual(a, b storage.Conversation) bool {
+	return a.JID.Equal(b.JID) &&
+		a.Name == b.Name &&
+		a.Room == b.Room &&
+		a.Notify == b.Notify &&
+		a.MutedUntil.Equal(b.MutedUntil) &&
+		a.Unread == b.Unread &&
+		a.FirstUnread == b.FirstUnread
+}
+
+// TestConversationsReopen checks that the recent conversations list, including
+// unread state and notification settings, is kept when the database is
+// reopened.
+func TestConversationsReopen(t *testing.T) {
+	ctx := context.Background()
+	addr := jid.MustParse(testAccount + "/pda")
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+
+	mutedUntil := time.Unix(time.Now().Add(time.Hour).Unix(), 0)
+	for _, c := range []storage.Conversation{
+		{JID: jid.MustParse("juliet@example.net"), Name: "Juliet", Notify: "mentions"},
+		{JID: jid.MustParse("romeo@example.net"), MutedUntil: mutedUntil},
+		{JID: jid.MustParse("coven@chat.example.net"), Room: true, Notify: "never"},
+		{JID: jid.MustParse("nurse@example.net")},
+	} {
+		err := db.UpsertConversation(ctx, c)
+		if err != nil {
+			t.Fatalf("error adding conversation: %v", err)
+		}
+	}
+	// Updating a conversation doesn't move it to the end of the list.
+	err := db.UpsertConversation(ctx, storage.Conversation{
+		JID:    jid.MustParse("juliet@example.net"),
+		Name:   "Juliet Capulet",
+		Notify: "always",
+	})
+	if err != nil {
+		t.Fatalf("error updating conversation: %v", err)
+	}
+	err = db.DeleteConversation(ctx, jid.MustParse("nurse@example.net"))
+	if err != nil {
+		t.Fatalf("error deleting conversation: %v", err)
+	}
+	for _, msg := range []event.ChatMessage{
+		delayedMsg("juliet@example.net/balcony", "j1", 100),
+		delayedMsg("juliet@example.net/balcony", "j2", 200),
+		delayedMsg("romeo@example.net/orchard", "r1", 100),
+	} {
+		err = db.InsertMsg(ctx, true, msg, addr)
+		if err != nil {
+			t.Fatalf("error inserting message: %v", err)
+		}
+	}
+	err = db.MarkConversationRead(ctx, jid.MustParse("romeo@example.net"))
+	if err != nil {
+		t.Fatalf("error marking conversation read: %v", err)
+	}
+
+	expected := []storage.Conversation{
+		{JID: jid.MustParse("juliet@example.net"), Name: "Juliet Capulet", Notify: "always", Unread: true, FirstUnread: "j1"},
+		{JID: jid.MustParse("romeo@example.net"), MutedUntil: mutedUntil},
+		{JID: jid.MustParse("coven@chat.example.net"), Room: true, Notify: "never"},
+	}
+	if convs := conversations(t, db); !slices.EqualFunc(convs, expected, convEqual) {
+		t.Fatalf("wrong conversations: want=%+v, got=%+v", expected, convs)
+	}
+
+	err = db.Close()
+	if err != nil {
+		t.Fatalf("error closing database: %v", err)
+	}
+	db = openTestDB(t, dir)
+	if convs := conversations(t, db); !slices.EqualFunc(convs, expected, convEqual) {
+		t.Fatalf("wrong conversations after reopening: want=%+v, got=%+v", expected, convs)
+	}
+
+	// Read state keeps working after reopening.
+	err = db.MarkConversationRead(ctx, jid.MustParse("juliet@example.net"))
+	if err != nil {
+		t.Fatalf("error marking conversation read: %v", err)
+	}
+	err = db.InsertMsg(ctx, true, delayedMsg("romeo@example.net/orchard", "r2", 300), addr)
+	if err != nil {
+		t.Fatalf("error inserting message: %v", err)
+	}
+	expected[0].Unread, expected[0].FirstUnread = false, ""
+	expected[1].Unread, expected[1].FirstUnread = true, "r2"
+	if convs := conversations(t, db); !slices.EqualFunc(convs, expected, convEqual) {
+		t.Errorf("wrong conversations after reading: want=%+v, got=%+v", expected, convs)
+	}
+}
@@ -11,12 +11,15 @@ import (
 	"log"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/rivo/tview"
 
+	"mellium.im/communique/internal/client"
 	"mellium.im/communique/internal/client/event"
 	"mellium.im/communique/internal/storage"
 	"mellium.im/communique/internal/ui"
+	"mellium.im/xmpp/jid"
 	"mellium.im/xmpp/roster"
 	"mellium.im/xmpp/stanza"
 	"mellium.im/xmpp/styling"
@@ -46,34 +49,74 @@ func writeMask(buf *strings.Builder, mask styling.Style) {
 }
 
 func writeMessage(pane *ui.UI, msg event.ChatMessage, notNew bool) error {
-	if msg.Body == "" {
+	if msg.Body == "" && !msg.Retracted {
 		return nil
 	}
 
-	historyAddr := msg.From
 	arrow := "←"
+	receipt := ui.ReceiptNone
 	if msg.Sent {
-		historyAddr = msg.To
 		arrow = "→"
+		// Delivery receipts are not used in group chats.
+		if msg.Type != stanza.GroupChatMessage && !msg.Retracted {
+			switch {
+			case msg.Failed:
+				receipt = ui.ReceiptFailed
+			case msg.Received || msg.Displayed:
+				receipt = ui.ReceiptDelivered
+			default:
+				receipt = ui.ReceiptPending
+			}
+			arrow += " " + ui.ReceiptIndicator(receipt)
+		}
+	}
+
+	// Messages in group chats that mention us or match one of the highlight
+	// rules are colored and counted separately.
+	room := msg.From.Bare()
+	highlight := msg.Type == stanza.GroupChatMessage && !msg.Sent && !msg.Retracted
+	mentioned := highlight && pane.Mentions(room, msg.Body)
+
+	var quote string
+	if msg.Quoted != nil {
+		quote = "[::d]↱ " + quoteSnippet(pane, *msg.Quoted) + "[::-]\n"
+		msg.Body = displayBody(msg)
 	}
 
 	var buf strings.Builder
-	var prevEnd bool
-	msg.Body = tview.Escape(msg.Body)
-	d := styling.NewDecoder(strings.NewReader(msg.Body))
-	for d.Next() {
-		tok := d.Token()
-		if prevEnd || tok.Mask != 0 {
-			prevEnd = false
-			writeMask(&buf, tok.Mask)
-		}
-		/* #nosec */
-		buf.Write(tok.Data)
-		if tok.Mask&styling.SpanEndDirective != 0 {
-			prevEnd = true
+	if msg.Retracted {
+		p := pane.Printer()
+		buf.WriteString("[::d]")
+		buf.WriteString(tview.Escape(p.Sprintf("message retracted")))
+		buf.WriteString("[::-]")
+	} else {
+		var prevEnd bool
+		// Each piece of the body is escaped as it is written so that the highlight
+		// rules are matched against the text that was actually sent.
+		d := styling.NewDecoder(strings.NewReader(msg.Body))
+		for d.Next() {
+			tok := d.Token()
+			if prevEnd || tok.Mask != 0 {
+				prevEnd = false
+				writeMask(&buf, tok.Mask)
+			}
+			if highlight {
+				buf.WriteString(pane.Highlight(room, string(tok.Data)))
+			} else {
+				buf.WriteString(tview.Escape(string(tok.Data)))
+			}
+			if tok.Mask&styling.SpanEndDirective != 0 {
+				prevEnd = true
+			}
 		}
+		buf.WriteString("[::-]")
+	}
+
+	// Messages that weren't delayed were sent just now.
+	sent := msg.Delay.Time
+	if sent.IsZero() {
+		sent = time.Now()
 	}
-	buf.WriteString("[::-]")
 
 	var historyLine string
 	if msg.Type == stanza.GroupChatMessage {
@@ -81,19 +124,39 @@ func writeMessage(pane *ui.UI, msg event.ChatMessage, notNew bool) error {
 		if msg.Sent {
 			j = msg.To
 		}
-		historyLine = fmt.Sprintf("%s %s [%s] %s\n", time.Now().UTC().Format(time.RFC3339), arrow, tview.Escape(j.Resourcepart()), buf.String())
+		historyLine = fmt.Sprintf("%s %s [%s] %s", tview.Escape(pane.FormatTime(sent)), arrow, tview.Escape(j.Resourcepart()), buf.String())
 	} else {
-		historyLine = fmt.Sprintf("%s %s %s\n", time.Now().UTC().Format(time.RFC3339), arrow, buf.String())
+		historyLine = fmt.Sprintf("%s %s %s", tview.Escape(pane.FormatTime(sent)), arrow, buf.String())
 	}
 
 	history := pane.History()
 
-	j := historyAddr.Bare()
+	j := msg.With()
 	if pane.ChatsOpen() {
 		if selected := pane.GetRosterJID(); j.Equal(selected) {
 			// If the message JID is selected and the window is open, write it to the
-			// history window.
-			_, err := io.WriteString(history, historyLine)
+			// history window wrapped in a region so that it can be selected.
+			m := ui.Message{
+				ID:        msg.ID,
+				JID:       j,
+				Type:      msg.Type,
+				Sent:      msg.Sent,
+				Retracted: msg.Retracted,
+				Receipt:   receipt,
+			}
+			if !msg.Sent {
+				m.From = msg.From
+			}
+			for _, sid := range msg.SID {
+				if sid.By.Equal(j) {
+					m.StanzaID = sid.ID
+					break
+				}
+			}
+			if reactions := reactionLine(msg.Reactions.Reactions); reactions != "" {
+				historyLine += "\n    [::d]" + reactions + "[::-]"
+			}
+			_, err := fmt.Fprintf(history, "%s[\"%s\"]%s%s[\"\"]\n", pane.DaySeparator(sent), pane.MessageRegion(m), quote, historyLine)
 			return err
 		}
 	}
@@ -104,33 +167,298 @@ func writeMessage(pane *ui.UI, msg event.ChatMessage, notNew bool) error {
 	if !msg.Sent && !notNew {
 		// Always try to create the item in the conversations pane.
 		// If it already exists, move it to the front.
-		pane.UpdateConversations(ui.Conversation{
+		c := ui.Conversation{
 			JID: j,
 			// TODO: get the preferred nickname.
 			Name: j.Localpart(),
-		})
+			Room: msg.Type == stanza.GroupChatMessage,
+		}
+		if msg.Private != nil {
+			c.Name = ui.PrivateName(j)
+		}
+		pane.UpdateConversations(c)
 		pane.MarkUnread(j.String(), msg.ID)
+		if mentioned {
+			pane.MarkMentioned(j.String())
+		}
 		pane.Redraw()
 	}
 	return nil
 }
 
+// maxSnippet is the maximum length of the snippet of a message shown above
+// replies to it.
+const maxSnippet = 50
+
+// quoteSnippet returns the first line of a message that is being replied to,
+// shortened and escaped so that it can be shown above the reply.
+func quoteSnippet(pane *ui.UI, quoted event.ChatMessage) string {
+	if quoted.Retracted {
+		p := pane.Printer()
+		return tview.Escape(p.Sprintf("message retracted"))
+	}
+	// Skip over any quotes at the start of the message (eg. if it was a reply
+	// itself) so that we show what was actually said.
+	body := strings.TrimSpace(quoted.Body)
+	for strings.HasPrefix(body, ">") {
+		_, rest, ok := strings.Cut(body, "\n")
+		if !ok {
+			break
+		}
+		body = strings.TrimSpace(rest)
+	}
+	snippet, _, more := strings.Cut(body, "\n")
+	if r := []rune(snippet); len(r) > maxSnippet {
+		snippet = string(r[:maxSnippet])
+		more = true
+	}
+	if more {
+		snippet += "…"
+	}
+	return tview.Escape(snippet)
+}
+
+// displayBody returns the body of a message without the fallback quote if it
+// is a reply to a message that we know about.
+// If we don't have the original message, the quote is the only context we have
+// so it is left in place.
+func displayBody(msg event.ChatMessage) string {
+	if msg.Quoted == nil {
+		return msg.Body
+	}
+	body := []rune(msg.Body)
+	for _, f := range msg.Fallback {
+		if f.For != client.NSReply {
+			continue
+		}
+		if len(f.Body) == 0 {
+			return ""
+		}
+		for _, b := range f.Body {
+			if b.Start < 0 || b.Start > b.End || b.End > len(body) {
+				continue
+			}
+			body = append(body[:b.Start:b.Start], body[b.End:]...)
+			// Only the first range is supported since removing it shifts the
+			// offsets of any others.
+			return string(body)
+		}
+	}
+	return string(body)
+}
+
+// withQuote looks up the message that msg replies to, if any, so that it can
+// be shown along with the reply.
+func withQuote(ctx context.Context, pane *ui.UI, db *storage.DB, msg event.ChatMessage, addr jid.JID, logger *log.Logger) event.ChatMessage {
+	if msg.Reply.ID == "" || msg.Quoted != nil {
+		return msg
+	}
+	quoted, err := db.QuotedMsg(ctx, msg, addr)
+	if err != nil {
+		p := pane.Printer()
+		logger.Print(p.Sprintf("error looking up message %q: %v", msg.Reply.ID, err))
+	}
+	msg.Quoted = quoted
+	return msg
+}
+
+// quoteReply turns msg into a reply (XEP-0461) to the message with the given
+// ID and adds a quote of the original message as a fallback for clients that
+// don't support replies.
+func quoteReply(ctx context.Context, pane *ui.UI, db *storage.DB, msg event.ChatMessage, author jid.JID, id string, addr jid.JID, logger *log.Logger) event.ChatMessage {
+	msg.Reply.To = author
+	msg.Reply.ID = id
+	msg = withQuote(ctx, pane, db, msg, addr, logger)
+	if msg.Quoted == nil || msg.Quoted.Retracted {
+		return msg
+	}
+	var quote strings.Builder
+	for _, line := range strings.Split(strings.TrimSpace(displayBody(*msg.Quoted)), "\n") {
+		quote.WriteString("> ")
+		quote.WriteString(line)
+		quote.WriteString("\n")
+	}
+	msg.Body = quote.String() + msg.Body
+	msg.Fallback = append(msg.Fallback, event.Fallback{
+		For:  client.NSReply,
+		Body: []event.FallbackBody{{Start: 0, End: utf8.RuneCountInString(quote.String())}},
+	})
+	return msg
+}
+
+// reactionLine returns a compact summary of the reactions to a message, with
+// a count after each reaction that was sent more than once.
+func reactionLine(reactions []string) string {
+	var order []string
+	counts := make(map[string]int)
+	for _, reaction := range reactions {
+		if counts[reaction] == 0 {
+			order = append(order, reaction)
+		}
+		counts[reaction]++
+	}
+	var buf strings.Builder
+	for i, reaction := range order {
+		if i > 0 {
+			buf.WriteString("  ")
+		}
+		buf.WriteString(tview.Escape(reaction))
+		if n := counts[reaction]; n > 1 {
+			fmt.Fprintf(&buf, " %d", n)
+		}
+	}
+	return buf.String()
+}
+
+// markRead marks the conversation with j as read in the UI and records the
+// last message that was read so that it is still read after a restart.
+func markRead(ctx context.Context, pane *ui.UI, db *storage.DB, j jid.JID, logger *log.Logger) {
+	pane.MarkRead(j.String())
+	if err := db.MarkConversationRead(ctx, j); err != nil {
+		p := pane.Printer()
+		logger.Print(p.Sprintf("error saving read state for %s: %v", j, err))
+	}
+}
+
+// loadConversations restores the recent conversations list and any unread
+// markers from the database.
+func loadConversations(ctx context.Context, pane *ui.UI, db *storage.DB) error {
+	return db.ForConversations(ctx, func(c storage.Conversation) {
+		pane.UpdateConversations(ui.Conversation{
+			JID:        c.JID,
+			Name:       c.Name,
+			Room:       c.Room,
+			Notify:     ui.NotifyPolicy(c.Notify),
+			MutedUntil: c.MutedUntil,
+		})
+		if c.Unread {
+			pane.MarkUnread(c.JID.String(), c.FirstUnread)
+		}
+	})
+}
+
+// minHistoryPage is the smallest number of messages loaded into the
+// conversation view at a time.
+const minHistoryPage = 50
+
+// historyPageSize returns the number of messages to load into the conversation
+// view at a time: enough to fill the screen with some to spare.
+func historyPageSize(pane *ui.UI) int {
+	_, _, _, height := pane.GetRect()
+	return max(2*height, minHistoryPage)
+}
+
+// loadBuffer replaces the open conversation with the most recent page of its
+// history.
 func loadBuffer(ctx context.Context, pane *ui.UI, db *storage.DB, ev roster.Item, msgID string, logger *log.Logger) error {
+	err := writeHistory(ctx, pane, db, ev, msgID, nil, storage.Page{Limit: historyPageSize(pane)}, logger)
+	pane.History().ScrollToEnd()
+	return err
+}
+
+// reloadBuffer redraws the messages that have already been loaded into the open
+// conversation after one of them changed, keeping any older pages that were
+// loaded and the scroll position.
+func reloadBuffer(ctx context.Context, pane *ui.UI, db *storage.DB, ev roster.Item, logger *log.Logger) error {
+	cursor := pane.HistoryCursor()
+	if cursor == 0 {
+		return loadBuffer(ctx, pane, db, ev, "", logger)
+	}
+	// The oldest loaded message is the first one after the message before it, or
+	// the first message in the conversation if there is nothing before it.
+	var page storage.Page
+	iter := db.QueryHistory(ctx, ev.JID.String(), "", storage.Page{
+		Before: cursor,
+		Limit:  1,
+	})
+	for iter.Next() {
+		page.After = iter.RowID()
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	var err error
+	pane.RewriteHistory(func() {
+		err = writeHistory(ctx, pane, db, ev, "", nil, page, logger)
+	})
+	return err
+}
+
+// loadOlder adds the page of history before the oldest message in the open
+// conversation to the start of the conversation without moving the messages
+// that are currently visible.
+// It reports whether any older messages were found in the database.
+func loadOlder(ctx context.Context, pane *ui.UI, db *storage.DB, ev roster.Item, logger *log.Logger) (bool, error) {
+	var older []event.ChatMessage
+	var first, last int64
+	iter := db.QueryHistory(ctx, ev.JID.String(), "", storage.Page{
+		Before: pane.HistoryCursor(),
+		Limit:  historyPageSize(pane),
+	})
+	for iter.Next() {
+		if first == 0 {
+			first = iter.RowID()
+		}
+		older = append(older, iter.Message())
+		last = iter.RowID()
+	}
+	if err := iter.Err(); err != nil {
+		return false, err
+	}
+	if len(older) == 0 {
+		return false, nil
+	}
+	var err error
+	pane.PrependHistory(func() {
+		err = writeHistory(ctx, pane, db, ev, "", older, storage.Page{After: last}, logger)
+		pane.SetHistoryCursor(first)
+	})
+	return true, err
+}
+
+// writeHistory replaces the open conversation with the messages in older
+// followed by the messages in page and records the first message in page as
+// the oldest one that has been loaded.
+// If msgID is the ID of one of the messages, an unread marker is written before
+// it.
+func writeHistory(ctx context.Context, pane *ui.UI, db *storage.DB, ev roster.Item, msgID string, older []event.ChatMessage, page storage.Page, logger *log.Logger) error {
 	history := pane.History()
-	history.SetText("")
+	pane.ClearHistory()
 	p := pane.Printer()
 
-	iter := db.QueryHistory(ctx, ev.JID.String(), "")
-	for iter.Next() {
-		cur := iter.Message()
+	var lastSent, last event.ChatMessage
+	write := func(cur event.ChatMessage) error {
+		if cur.Body != "" {
+			last = cur
+		}
+		if cur.Sent && cur.Body != "" && !cur.Retracted && cur.Type != stanza.GroupChatMessage {
+			lastSent = cur
+			lastSent.Body = displayBody(cur)
+		}
 		if cur.ID != "" && cur.ID == msgID {
 			_, err := io.WriteString(history, "─\n")
 			if err != nil {
 				return err
 			}
 		}
-		err := writeMessage(pane, cur, true)
-		if err != nil {
+		return writeMessage(pane, cur, true)
+	}
+	var cursor int64
+	for _, cur := range older {
+		if err := write(cur); err != nil {
+			msg := p.Sprintf("error writing history: %v", err)
+			history.SetText(msg)
+			logger.Println(msg)
+			return nil
+		}
+	}
+	iter := db.QueryHistory(ctx, ev.JID.String(), "", page)
+	for iter.Next() {
+		if cursor == 0 {
+			cursor = iter.RowID()
+		}
+		if err := write(iter.Message()); err != nil {
+			iter.Close()
 			msg := p.Sprintf("error writing history: %v", err)
 			history.SetText(msg)
 			logger.Println(msg)
@@ -141,6 +469,96 @@ func loadBuffer(ctx context.Context, pane *ui.UI, db *storage.DB, ev roster.Item
 		history.SetText(err.Error())
 		logger.Print(p.Sprintf("error querying history for %s: %v", ev.JID, err))
 	}
-	history.ScrollToEnd()
+	pane.SetHistoryCursor(cursor)
+	// Show whether the last message that we sent has been seen, unless the
+	// other person has already replied to it.
+	if last.Sent && last.Displayed {
+		_, err := io.WriteString(history, "    [::d]"+tview.Escape(p.Sprintf("✓ seen"))+"[::-]\n")
+		if err != nil {
+			return err
+		}
+	}
+	pane.SetLastSent(ev.JID, lastSent.ID, lastSent.Body)
 	return nil
 }
+
+// correctMessage applies a message correction (XEP-0308) to the stored message
+// that it replaces and redraws the conversation if it is open.
+// If the original message cannot be found false is returned and the correction
+// should be treated as a new message.
+func correctMessage(ctx context.Context, pane *ui.UI, db *storage.DB, msg event.ChatMessage, addr jid.JID, logger *log.Logger) (bool, error) {
+	ok, err := db.CorrectMsg(ctx, msg, addr)
+	if err != nil || !ok {
+		return ok, err
+	}
+	j := msg.With()
+	if pane.ChatsOpen() && j.Equal(pane.GetRosterJID()) {
+		err = reloadBuffer(ctx, pane, db, roster.Item{JID: j}, logger)
+	}
+	return true, err
+}
+
+// retractMessage replaces a stored message with a tombstone after it is
+// retracted (XEP-0424) or moderated (XEP-0425) and redraws the conversation if
+// it is open.
+// If the original message cannot be found false is returned.
+func retractMessage(ctx context.Context, pane *ui.UI, db *storage.DB, msg event.ChatMessage, addr jid.JID, logger *log.Logger) (bool, error) {
+	ok, err := db.RetractMsg(ctx, msg, addr)
+	if err != nil || !ok {
+		return ok, err
+	}
+	j := msg.With()
+	if pane.ChatsOpen() && j.Equal(pane.GetRosterJID()) {
+		err = reloadBuffer(ctx, pane, db, roster.Item{JID: j}, logger)
+	}
+	return true, err
+}
+
+// applyRetraction applies a retraction that we received, either directly or
+// from the history.
+// In group chats only moderation by the group chat itself and retractions of
+// our own messages are supported because we only store the bare JID of the
+// group chat as the sender and can't tell which other occupant sent the
+// original message.
+func applyRetraction(ctx context.Context, pane *ui.UI, db *storage.DB, msg event.ChatMessage, addr jid.JID, logger *log.Logger) (bool, error) {
+	if msg.Type == stanza.GroupChatMessage && !msg.Sent && !msg.From.Equal(msg.From.Bare()) {
+		return false, nil
+	}
+	return retractMessage(ctx, pane, db, msg, addr, logger)
+}
+
+// reactMessage applies reactions (XEP-0444) to the stored message that they
+// reference and redraws the conversation if it is open.
+// If the original message cannot be found false is returned.
+func reactMessage(ctx context.Context, pane *ui.UI, db *storage.DB, msg event.ChatMessage, addr jid.JID, logger *log.Logger) (bool, error) {
+	ok, err := db.React(ctx, msg, addr)
+	if err != nil || !ok {
+		return ok, err
+	}
+	j := msg.With()
+	if pane.ChatsOpen() && j.Equal(pane.GetRosterJID()) {
+		err = reloadBuffer(ctx, pane, db, roster.Item{JID: j}, logger)
+	}
+	return true, err
+}
+
+// applyMarker applies a displayed chat marker (XEP-0333).
+// Markers that we sent from another device mean that we've read the
+// conversation, and markers from the person that we're chatting with are shown
+// on the messages that they've read.
+func applyMarker(ctx context.Context, pane *ui.UI, db *storage.DB, msg event.ChatMessage, addr jid.JID, logger *log.Logger) error {
+	ok, err := db.MarkDisplayed(ctx, msg, addr)
+	if err != nil || !ok {
+		return err
+	}
+	if msg.Sent {
+		markRead(ctx, pane, db, msg.With(), logger)
+		pane.Redraw()
+		return nil
+	}
+	j := msg.With()
+	if pane.ChatsOpen() && j.Equal(pane.GetRosterJID()) {
+		err = reloadBuffer(ctx, pane, db, roster.Item{JID: j}, logger)
+	}
+	return err
+}
@@ -0,0 +1,185 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"mellium.im/xmpp/jid"
+)
+
+// maxSyncQueries is the maximum number of archive queries that the history
+// sync will run at once.
+const maxSyncQueries = 3
+
+// syncJob fetches the history of a single conversation.
+type syncJob struct {
+	JID jid.JID
+	// LastActive is the time of the last message that we have in the
+	// conversation (or the zero time if we don't have any history yet).
+	LastActive time.Time
+	Fetch      func(context.Context)
+}
+
+// historySync fetches the history of many conversations from the archive a few
+// at a time and in order of priority, reporting its progress as it goes.
+type historySync struct {
+	m        sync.Mutex
+	cond     *sync.Cond
+	queue    []syncJob
+	done     int
+	total    int
+	paused   bool
+	cancel   context.CancelFunc
+	progress func(done, total int, paused bool)
+}
+
+// newHistorySync creates a history sync that calls progress every time a job
+// finishes or the sync is paused, resumed, or cancelled.
+func newHistorySync(progress func(done, total int, paused bool)) *historySync {
+	s := &historySync{
+		progress: progress,
+	}
+	s.cond = sync.NewCond(&s.m)
+	return s
+}
+
+// Start cancels any sync that is already running and starts running the jobs.
+// The conversation that is open is synced first, then the most recently active
+// conversations, and conversations without any history last.
+func (s *historySync) Start(jobs []syncJob, open jid.JID) {
+	slices.SortStableFunc(jobs, func(a, b syncJob) int {
+		aOpen, bOpen := a.JID.Equal(open), b.JID.Equal(open)
+		switch {
+		case aOpen && !bOpen:
+			return -1
+		case bOpen && !aOpen:
+			return 1
+		}
+		return b.LastActive.Compare(a.LastActive)
+	})
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.stopLocked()
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.queue = jobs
+	s.done = 0
+	s.total = len(jobs)
+	s.paused = false
+	s.report()
+	for i := 0; i < maxSyncQueries && i < len(jobs); i++ {
+		go s.work(ctx)
+	}
+}
+
+// Prioritize moves the job for j (if it is still waiting to run) to the front
+// of the queue.
+func (s *historySync) Prioritize(j jid.JID) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for i, job := range s.queue {
+		if job.JID.Equal(j) {
+			copy(s.queue[1:i+1], s.queue[:i])
+			s.queue[0] = job
+			return
+		}
+	}
+}
+
+// Pause stops new jobs from being started until Resume is called.
+// Jobs that are already running are allowed to finish.
+func (s *historySync) Pause() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.paused || len(s.queue) == 0 {
+		return
+	}
+	s.paused = true
+	s.report()
+}
+
+// Resume continues a paused sync.
+func (s *historySync) Resume() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if !s.paused {
+		return
+	}
+	s.paused = false
+	s.report()
+	s.cond.Broadcast()
+}
+
+// Cancel stops the sync, including any jobs that are already running.
+func (s *historySync) Cancel() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.stopLocked()
+	s.done = 0
+	s.total = 0
+	s.paused = false
+	s.report()
+}
+
+func (s *historySync) stopLocked() {
+	if s.cancel != nil {
+		s.cancel()
+		s.cancel = nil
+	}
+	s.queue = nil
+	// Wake up any workers that are waiting on a paused sync so that they notice
+	// that they have been cancelled.
+	s.cond.Broadcast()
+}
+
+func (s *historySync) work(ctx context.Context) {
+	for {
+		job, ok := s.next(ctx)
+		if !ok {
+			return
+		}
+		job.Fetch(ctx)
+		s.m.Lock()
+		if ctx.Err() == nil {
+			s.done++
+			s.report()
+		}
+		s.m.Unlock()
+	}
+}
+
+// next waits until the sync is not paused and then pops the next job off the
+// queue.
+func (s *historySync) next(ctx context.Context) (syncJob, bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for s.paused && ctx.Err() == nil {
+		s.cond.Wait()
+	}
+	if ctx.Err() != nil || len(s.queue) == 0 {
+		return syncJob{}, false
+	}
+	job := s.queue[0]
+	s.queue = s.queue[1:]
+	return job, true
+}
+
+// report must be called with the lock held.
+func (s *historySync) report() {
+	if s.progress != nil {
+		s.progress(s.done, s.total, s.paused)
+	}
+}
@@ -0,0 +1,349 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"runtime"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"mellium.im/xmpp/jid"
+)
+
+// syncTimeout is how long the tests wait for something to happen, and
+// syncQuiet is how long they wait to make sure that something doesn't.
+const (
+	syncTimeout = time.Second
+	syncQuiet   = 50 * time.Millisecond
+)
+
+type syncProgress struct {
+	done, total int
+	paused      bool
+}
+
+// fakeFetcher records the jobs that are run and blocks them until they are
+// released or cancelled.
+type fakeFetcher struct {
+	started    chan string
+	release    chan struct{}
+	progress   chan syncProgress
+	running    atomic.Int32
+	maxRunning atomic.Int32
+}
+
+func newFakeFetcher() *fakeFetcher {
+	return &fakeFetcher{
+		started:  make(chan string, 100),
+		release:  make(chan struct{}),
+		progress: make(chan syncProgress, 100),
+	}
+}
+
+// jobs returns a job for each of the given local parts, with the first being
+// the most recently active.
+func (f *fakeFetcher) jobs(names ...string) []syncJob {
+	var jobs []syncJob
+	for i, name := range names {
+		j := jid.MustParse(name + "@example.net")
+		jobs = append(jobs, syncJob{
+			JID:        j,
+			LastActive: time.Unix(int64(len(names)-i), 0),
+			Fetch: func(ctx context.Context) {
+				running := f.running.Add(1)
+				for {
+					maxRunning := f.maxRunning.Load()
+					if running <= maxRunning || f.maxRunning.CompareAndSwap(maxRunning, running) {
+						break
+					}
+				}
+				f.started <- name
+				select {
+				case <-f.release:
+				case <-ctx.Done():
+				}
+				f.running.Add(-1)
+			},
+		})
+	}
+	return jobs
+}
+
+func (f *fakeFetcher) report(done, total int, paused bool) {
+	f.progress <- syncProgress{done: done, total: total, paused: paused}
+}
+
+// waitStarted waits for n jobs to start and returns them in the order they
+// started.
+func (f *fakeFetcher) waitStarted(t *testing.T, n int) []string {
+	t.Helper()
+	var started []string
+	for len(started) < n {
+		select {
+		case name := <-f.started:
+			started = append(started, name)
+		case <-time.After(syncTimeout):
+			t.Fatalf("timed out waiting for jobs to start: want=%d, got=%v", n, started)
+		}
+	}
+	return started
+}
+
+// expectNoStart fails the test if any job starts in the next syncQuiet.
+func (f *fakeFetcher) expectNoStart(t *testing.T) {
+	t.Helper()
+	select {
+	case name := <-f.started:
+		t.Fatalf("job %s started unexpectedly", name)
+	case <-time.After(syncQuiet):
+	}
+}
+
+// waitProgress waits for the sync to report the given progress.
+func (f *fakeFetcher) waitProgress(t *testing.T, want syncProgress) {
+	t.Helper()
+	timeout := time.After(syncTimeout)
+	for {
+		select {
+		case got := <-f.progress:
+			if got == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for progress %+v", want)
+		}
+	}
+}
+
+// waitGoroutines waits for the number of goroutines to drop back to n.
+func waitGoroutines(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(syncTimeout)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			t.Fatalf("leaked goroutines: want=%d, got=%d", n, runtime.NumGoroutine())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+var syncOrderTestCases = [...]struct {
+	jobs     []string
+	open     string
+	inactive []string
+	expected []string
+}{
+	0: {
+		jobs:     []string{"a", "b", "c", "d", "e"},
+		expected: []string{"a", "b", "c", "d", "e"},
+	},
+	1: {
+		// The open conversation is synced first.
+		jobs:     []string{"a", "b", "c", "d", "e"},
+		open:     "e",
+		expected: []string{"e", "a", "b", "c", "d"},
+	},
+	2: {
+		// Conversations without any history are synced last, in the order that
+		// they were given.
+		jobs:     []string{"a", "b", "c", "d", "e", "f"},
+		inactive: []string{"a", "c"},
+		expected: []string{"b", "d", "e", "f", "c", "a"},
+	},
+}
+
+func TestSyncOrder(t *testing.T) {
+	for i, tc := range syncOrderTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			f := newFakeFetcher()
+			s := newHistorySync(f.report)
+			defer s.Cancel()
+
+			// Reverse the jobs so that they aren't already in order.
+			jobs := f.jobs(tc.jobs...)
+			slices.Reverse(jobs)
+			for i, job := range jobs {
+				if slices.ContainsFunc(tc.inactive, func(name string) bool {
+					return job.JID.Localpart() == name
+				}) {
+					jobs[i].LastActive = time.Time{}
+				}
+			}
+			var open jid.JID
+			if tc.open != "" {
+				open = jid.MustParse(tc.open + "@example.net")
+			}
+			s.Start(jobs, open)
+
+			// The first jobs start at the same time, so they may start in any
+			// order, but after that each job that finishes lets the next one start.
+			started := f.waitStarted(t, maxSyncQueries)
+			slices.SortFunc(started, func(a, b string) int {
+				return slices.Index(tc.expected, a) - slices.Index(tc.expected, b)
+			})
+			for range len(tc.expected) - maxSyncQueries {
+				f.release <- struct{}{}
+				started = append(started, f.waitStarted(t, 1)...)
+			}
+			if !slices.Equal(started, tc.expected) {
+				t.Errorf("wrong order: want=%v, got=%v", tc.expected, started)
+			}
+		})
+	}
+}
+
+func TestSyncConcurrency(t *testing.T) {
+	f := newFakeFetcher()
+	s := newHistorySync(f.report)
+	defer s.Cancel()
+
+	names := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}
+	s.Start(f.jobs(names...), jid.JID{})
+	f.waitProgress(t, syncProgress{total: len(names)})
+	started := f.waitStarted(t, maxSyncQueries)
+	f.expectNoStart(t)
+	for range len(names) - maxSyncQueries {
+		f.release <- struct{}{}
+		started = append(started, f.waitStarted(t, 1)...)
+	}
+	for range maxSyncQueries {
+		f.release <- struct{}{}
+	}
+	f.waitProgress(t, syncProgress{done: len(names), total: len(names)})
+
+	if n := f.maxRunning.Load(); n != maxSyncQueries {
+		t.Errorf("wrong number of concurrent jobs: want=%d, got=%d", maxSyncQueries, n)
+	}
+	slices.Sort(started)
+	if !slices.Equal(started, names) {
+		t.Errorf("each job should run once: want=%v, got=%v", names, started)
+	}
+}
+
+var syncPrioritizeTestCases = [...]struct {
+	prioritize []string
+	expected   []string
+}{
+	0: {
+		expected: []string{"d", "e", "f"},
+	},
+	1: {
+		prioritize: []string{"f"},
+		expected:   []string{"f", "d", "e"},
+	},
+	2: {
+		prioritize: []string{"e", "f"},
+		expected:   []string{"f", "e", "d"},
+	},
+	3: {
+		// Jobs that already ran or aren't in the queue are ignored.
+		prioritize: []string{"a", "z"},
+		expected:   []string{"d", "e", "f"},
+	},
+}
+
+func TestSyncPrioritize(t *testing.T) {
+	for i, tc := range syncPrioritizeTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			f := newFakeFetcher()
+			s := newHistorySync(f.report)
+			defer s.Cancel()
+
+			s.Start(f.jobs("a", "b", "c", "d", "e", "f"), jid.JID{})
+			f.waitStarted(t, maxSyncQueries)
+			for _, name := range tc.prioritize {
+				s.Prioritize(jid.MustParse(name + "@example.net"))
+			}
+			var started []string
+			for range tc.expected {
+				f.release <- struct{}{}
+				started = append(started, f.waitStarted(t, 1)...)
+			}
+			if !slices.Equal(started, tc.expected) {
+				t.Errorf("wrong order: want=%v, got=%v", tc.expected, started)
+			}
+		})
+	}
+}
+
+func TestSyncPause(t *testing.T) {
+	f := newFakeFetcher()
+	s := newHistorySync(f.report)
+	defer s.Cancel()
+
+	names := []string{"a", "b", "c", "d", "e"}
+	s.Start(f.jobs(names...), jid.JID{})
+	f.waitStarted(t, maxSyncQueries)
+	s.Pause()
+	f.waitProgress(t, syncProgress{total: len(names), paused: true})
+
+	// Running jobs finish, but no new ones are started.
+	for range maxSyncQueries {
+		f.release <- struct{}{}
+	}
+	f.waitProgress(t, syncProgress{done: maxSyncQueries, total: len(names), paused: true})
+	f.expectNoStart(t)
+
+	s.Resume()
+	f.waitProgress(t, syncProgress{done: maxSyncQueries, total: len(names)})
+	f.waitStarted(t, len(names)-maxSyncQueries)
+	for range len(names) - maxSyncQueries {
+		f.release <- struct{}{}
+	}
+	f.waitProgress(t, syncProgress{done: len(names), total: len(names)})
+}
+
+var syncCancelTestCases = [...]struct {
+	pause   bool
+	restart bool
+}{
+	0: {},
+	1: {pause: true},
+	2: {restart: true},
+}
+
+func TestSyncCancel(t *testing.T) {
+	for i, tc := range syncCancelTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			goroutines := runtime.NumGoroutine()
+			f := newFakeFetcher()
+			s := newHistorySync(f.report)
+
+			names := []string{"a", "b", "c", "d", "e"}
+			s.Start(f.jobs(names...), jid.JID{})
+			f.waitStarted(t, maxSyncQueries)
+			if tc.pause {
+				// Let the workers finish their jobs so that they are waiting for the
+				// sync to be resumed.
+				s.Pause()
+				for range maxSyncQueries {
+					f.release <- struct{}{}
+				}
+				f.waitProgress(t, syncProgress{done: maxSyncQueries, total: len(names), paused: true})
+			}
+			if tc.restart {
+				// Starting a new sync cancels the old one.
+				s.Start(f.jobs("z"), jid.JID{})
+				if started := f.waitStarted(t, 1); started[0] != "z" {
+					t.Fatalf("wrong job started after restart: want=z, got=%s", started[0])
+				}
+				f.release <- struct{}{}
+				f.waitProgress(t, syncProgress{done: 1, total: 1})
+			} else {
+				s.Cancel()
+				f.waitProgress(t, syncProgress{})
+			}
+			f.expectNoStart(t)
+			if n := f.running.Load(); n != 0 {
+				t.Errorf("jobs still running after cancel: %d", n)
+			}
+			waitGoroutines(t, goroutines)
+		})
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+	"mellium.im/xmpp/stanza"
+)
+
+// statusCreated is the MUC status code that is sent when joining a group chat
+// creates it.
+const statusCreated = 201
+
+// ChannelConfig fetches the configuration form of a group chat that we own.
+func (c *Client) ChannelConfig(ctx context.Context, room jid.JID) (*form.Data, error) {
+	return muc.GetConfig(ctx, room.Bare(), c.Session)
+}
+
+// ConfigureChannel submits the configuration form of a group chat that we own.
+// If f is nil an empty form is submitted which accepts the default
+// configuration (eg. to unlock a newly created "instant" group chat).
+// If the group chat rejects the configuration the error returned is the
+// stanza.Error sent by the group chat.
+func (c *Client) ConfigureChannel(ctx context.Context, room jid.JID, f *form.Data) error {
+	submission, _ := f.Submit()
+	return c.setOwnerConfig(ctx, room, submission)
+}
+
+// CancelChannelConfig cancels configuring a group chat.
+// If the group chat was just created and is still locked waiting for its
+// configuration, the group chat is destroyed.
+func (c *Client) CancelChannelConfig(ctx context.Context, room jid.JID) error {
+	return c.setOwnerConfig(ctx, room, form.Cancel("", "").TokenReader())
+}
+
+func (c *Client) setOwnerConfig(ctx context.Context, room jid.JID, payload xml.TokenReader) error {
+	return c.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		payload,
+		xml.StartElement{Name: xml.Name{Space: muc.NSOwner, Local: "query"}},
+	), stanza.IQ{
+		Type: stanza.SetIQ,
+		To:   room.Bare(),
+	}, nil)
+}
@@ -0,0 +1,102 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"encoding/xml"
+	"slices"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/disco/info"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// nsChatStates is the namespace used by Chat State Notifications (XEP-0085).
+const nsChatStates = "http://jabber.org/protocol/chatstates"
+
+// chatStates is the list of chat states defined by XEP-0085.
+var chatStates = []string{
+	event.StateActive,
+	event.StateComposing,
+	event.StatePaused,
+	event.StateInactive,
+	event.StateGone,
+}
+
+// SendChatState sends a standalone chat state notification such as
+// "composing" or "paused" to the given address.
+// If we are offline or they don't support chat states, nothing is sent.
+func (c *Client) SendChatState(ctx context.Context, to jid.JID, state string) error {
+	if !c.online || !c.supportsChatStates(to) {
+		return nil
+	}
+	return c.Send(ctx, stanza.Message{
+		To:   to,
+		Type: stanza.ChatMessage,
+	}.Wrap(chatStateToken(state)))
+}
+
+// supportsChatStates reports whether j has sent us chat states or advertises
+// support for them.
+// The result is remembered so that we don't look it up every time we start
+// typing.
+func (c *Client) supportsChatStates(j jid.JID) bool {
+	key := j.Bare().String()
+	if ok, found := c.chatStatePeers.Load(key); found {
+		return ok.(bool)
+	}
+	discoInfo, err := c.Disco(j)
+	if err != nil {
+		c.debug.Print(c.Printer().Sprintf("error discovering chat state support for %s: %v", j, err))
+		return false
+	}
+	ok := slices.ContainsFunc(discoInfo.Features, func(f info.Feature) bool {
+		return f.Var == nsChatStates
+	})
+	c.chatStatePeers.Store(key, ok)
+	return ok
+}
+
+func chatStateToken(state string) xml.TokenReader {
+	if state == "" {
+		// Returns nil, EOF
+		return xmlstream.Token(nil)
+	}
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: nsChatStates, Local: state},
+	})
+}
+
+// handleChatStates returns mux options that emit ChatState events for every
+// chat state.
+// Chat states are only handled in one-to-one chats, not in group chats or
+// private messages from their occupants.
+func handleChatStates(c *Client) []mux.Option {
+	opts := make([]mux.Option, 0, len(chatStates))
+	for _, state := range chatStates {
+		opts = append(opts, mux.MessageFunc(
+			stanza.ChatMessage,
+			xml.Name{Space: nsChatStates, Local: state},
+			func(m stanza.Message, _ xmlstream.TokenReadEncoder) error {
+				// Chat states in private messages from group chat occupants would
+				// otherwise be shown on the group chat.
+				if c.InMUC(m.From) {
+					return nil
+				}
+				c.chatStatePeers.Store(m.From.Bare().String(), true)
+				c.handler(event.ChatState{
+					From:  m.From,
+					State: state,
+				})
+				return nil
+			},
+		))
+	}
+	return opts
+}
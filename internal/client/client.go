@@ -62,9 +62,11 @@ func New(j jid.JID, logger, debug *log.Logger, opts ...Option) *Client {
 		receiptsHandler: &receipts.Handler{
 			Unhandled: func(id string) { c.handler(event.Receipt(id)) },
 		},
-		// TODO: mediated muc invitations
 		mucClient: &muc.Client{},
 		channels:  make(map[string]*muc.Channel),
+		joinOpts:  make(map[string][]muc.Option),
+		joinedAt:  make(map[string]time.Time),
+		sm:        &streamManagement{},
 	}
 
 	for _, opt := range opts {
@@ -118,6 +120,7 @@ func (c *Client) reconnect(ctx context.Context) error {
 	ctx, cancel = context.WithTimeout(ctx, c.timeout)
 	defer cancel()
 
+	c.sm.reset()
 	conn, err := c.dialer.Dial(ctx, "tcp", c.addr)
 	if err != nil {
 		return localerr.Wrap(p, "error dialing connection: %v", err)
@@ -140,6 +143,12 @@ func (c *Client) reconnect(ctx context.Context) error {
 		saslFeature.Necessary &^= xmpp.Secure
 	}
 
+	// Always count outgoing stanzas in case stream management is enabled.
+	var teeOut io.Writer = c.sm
+	if c.wout != nil {
+		teeOut = io.MultiWriter(c.wout, c.sm)
+	}
+
 	negotiator := xmpp.NewNegotiator(func(*xmpp.Session, *xmpp.StreamConfig) xmpp.StreamConfig {
 		return xmpp.StreamConfig{
 			Features: []xmpp.StreamFeature{
@@ -147,10 +156,11 @@ func (c *Client) reconnect(ctx context.Context) error {
 				xmpp.StartTLS(c.dialer.TLSConfig),
 				saslFeature,
 				roster.Versioning(),
-				xmpp.BindResource(),
+				c.sm.feature(),
+				c.sm.bindOrResume(),
 			},
 			TeeIn:  c.win,
-			TeeOut: c.wout,
+			TeeOut: teeOut,
 		}
 	})
 	c.Session, err = xmpp.NewSession(ctx, c.addr.Domain(), c.addr, conn, 0, negotiator)
@@ -159,23 +169,53 @@ func (c *Client) reconnect(ctx context.Context) error {
 	}
 
 	c.online = true
+	// We're back online, so if this stream dies too a new reconnect loop must be
+	// started even if the one that got us here hasn't returned yet.
+	c.detachReconnect()
 
+	selfPingCtx, selfPingCancel := context.WithCancel(context.Background())
 	go func() {
-		err := c.Serve(newXMPPHandler(c))
+		err := c.Serve(c.sm.handler(c, newXMPPHandler(c)))
+		selfPingCancel()
 		if err != nil {
 			c.logger.Print(p.Sprintf("Error while handling XMPP streams: %q", err))
+		} else {
+			// If the stream was closed cleanly the session cannot be resumed.
+			c.sm.forget()
 		}
 
+		serveErr := err
 		c.handler(event.StatusOffline(c.LocalAddr()))
-		err = c.Offline()
+		err = c.closeSession()
 		if err != nil {
 			c.logger.Print(p.Sprintf("Error going offline: %q", err))
 		}
 		if err = conn.Close(); err != nil {
 			c.logger.Print(p.Sprintf("Error closing the connection: %q", err))
 		}
+		// If the stream died (as opposed to being closed by us or the server) try
+		// to get back online.
+		if serveErr != nil {
+			c.superviseReconnect()
+		}
 	}()
 
+	// If we resumed the previous session our carbons, roster, and bookmarks are
+	// all still valid, so just retransmit anything that was lost and skip
+	// setting up the rest of the session.
+	if c.sm.isResumed() {
+		c.debug.Print(p.Sprintf("resumed previous session"))
+		c.retransmit(ctx)
+		go c.selfPing(selfPingCtx)
+		return nil
+	}
+
+	err = c.sm.enable(ctx, c.Session)
+	if err != nil {
+		c.debug.Print(p.Sprintf("error enabling stream management: %v", err))
+	}
+	c.retransmit(ctx)
+
 	// If the stream contained entity capabilities, go ahead and send an alert so
 	// that we can fetch the disco
 	if caps, ok := disco.ServerCaps(c.Session); ok {
@@ -232,6 +272,10 @@ func (c *Client) reconnect(ctx context.Context) error {
 		c.logger.Print(p.Sprintf("error fetching bookmarks: %q", err))
 	}
 
+	// Make sure we're still joined to any group chats that were joined before
+	// we reconnected.
+	go c.selfPing(selfPingCtx)
+
 	return nil
 }
 
@@ -254,8 +298,32 @@ type Client struct {
 	mucClient       *muc.Client
 	chanM           sync.Mutex
 	channels        map[string]*muc.Channel
+	// joinOpts maps the bare JIDs of joined group chats to the options they were
+	// joined with so that they can be rejoined if we are dropped.
+	joinOpts map[string][]muc.Option
+	// joinedAt maps the bare JIDs of joined group chats to when they were last
+	// joined so that a self-ping sent before then doesn't cause a rejoin.
+	joinedAt map[string]time.Time
+	// leaving contains the bare JIDs of group chats that we are in the process
+	// of leaving.
+	leaving sync.Map
+	// chatStatePeers maps bare JIDs to whether they support chat states.
+	chatStatePeers sync.Map
+	// me maps the bare JIDs of joined group chats to our occupant JID.
+	// It is separate from channels so that it can be read by handlers while a
+	// group chat is being joined.
+	me sync.Map
+	// realJIDs maps the occupant JIDs of group chat occupants to their real bare
+	// JIDs in group chats that expose them.
+	realJIDs        sync.Map
 	p               *message.Printer
 	httpClient      *http.Client
+	sm              *streamManagement
+	reconnectM      sync.Mutex
+	cancelReconnect context.CancelFunc
+	// reconnectCtx is the context of the running reconnect loop, if any.
+	reconnectCtx context.Context
+	status       func(context.Context) error
 }
 
 // Printer returns the message printer that the client is using for
@@ -269,6 +337,7 @@ func (c *Client) Printer() *message.Printer {
 // have to re-establish the session, so if it includes a timeout make sure to
 // account for the fact that we might reconnect.
 func (c *Client) Online(ctx context.Context) error {
+	c.setStatus(c.Online)
 	err := c.reconnect(ctx)
 	if err != nil {
 		return err
@@ -405,6 +474,7 @@ func (c *Client) Roster(ctx context.Context) error {
 
 // Away sets the status to away.
 func (c *Client) Away(ctx context.Context) error {
+	c.setStatus(c.Away)
 	err := c.reconnect(ctx)
 	if err != nil {
 		return err
@@ -427,6 +497,7 @@ func (c *Client) Away(ctx context.Context) error {
 
 // Busy sets the status to busy.
 func (c *Client) Busy(ctx context.Context) error {
+	c.setStatus(c.Busy)
 	err := c.reconnect(ctx)
 	if err != nil {
 		return err
@@ -448,7 +519,18 @@ func (c *Client) Busy(ctx context.Context) error {
 }
 
 // Offline logs the client off.
+// Closing the stream ends the current session so it will not be resumed the
+// next time we go online.
+// If we were trying to reconnect after losing the connection, we stop.
 func (c *Client) Offline() error {
+	if c.stopReconnecting() {
+		c.handler(event.StatusOffline(c.LocalAddr()))
+	}
+	c.sm.forget()
+	return c.closeSession()
+}
+
+func (c *Client) closeSession() error {
 	if !c.online {
 		return nil
 	}
@@ -478,6 +560,10 @@ func (c *Client) Timeout() time.Duration {
 // SendMessage encodes the provided message to the output stream and adds a
 // request for a receipt. It then blocks until the message receipt is received,
 // or the context is canceled.
+//
+// If the client is offline, or the server does not acknowledge the message
+// before the connection is lost, the message is queued and sent again the next
+// time the client comes online.
 func (c *Client) SendMessage(ctx context.Context, msg event.ChatMessage) (event.ChatMessage, error) {
 	if msg.ID == "" {
 		id := randomID()
@@ -485,7 +571,32 @@ func (c *Client) SendMessage(ctx context.Context, msg event.ChatMessage) (event.
 		msg.OriginID.ID = id
 	}
 
-	return msg, c.Session.Send(ctx, receipts.Request(encodeMessage(msg)))
+	if c.isPrivate(msg.Type, msg.To) {
+		msg.Private = &struct{}{}
+	}
+
+	if !c.online {
+		c.sm.queue(msg, false)
+		return msg, nil
+	}
+	err := c.Session.Send(ctx, receipts.Request(encodeMessage(msg)))
+	c.sm.queue(msg, err == nil)
+	if err != nil {
+		return msg, err
+	}
+	return msg, c.sm.requestAck(ctx, c.Session)
+}
+
+// retransmit sends any messages that were queued while we were offline or that
+// were never acknowledged by the server.
+func (c *Client) retransmit(ctx context.Context) {
+	p := c.Printer()
+	for _, msg := range c.sm.pending() {
+		_, err := c.SendMessage(ctx, msg)
+		if err != nil {
+			c.logger.Print(p.Sprintf("error retransmitting message %q: %v", msg.ID, err))
+		}
+	}
 }
 
 func omitEmpty(s string, name xml.Name) xml.TokenReader {
@@ -502,44 +613,166 @@ func encodeMessage(e event.ChatMessage) xml.TokenReader {
 	e.Message.XMLName = xml.Name{Space: "jabber:client", Local: "message"}
 	return e.Message.Wrap(xmlstream.MultiReader(
 		omitEmpty(e.Body, xml.Name{Local: "body"}),
+		chatStateToken(e.ChatState),
+		replaceToken(e.Replace.ID),
+		retractToken(e.Retract.ID),
+		reactionsToken(e.Reactions.ID, e.Reactions.Reactions),
+		replyToken(e.Reply.To, e.Reply.ID),
+		markableToken(e),
+		fallbackToken(e.Fallback),
+		privateToken(e.Private != nil),
 		e.OriginID.TokenReader(),
 	))
 }
 
+// nsRetract is the namespace used by Message Retraction (XEP-0424).
+const nsRetract = "urn:xmpp:message-retract:1"
+
+func retractToken(id string) xml.TokenReader {
+	if id == "" {
+		// Returns nil, EOF
+		return xmlstream.Token(nil)
+	}
+	return xmlstream.MultiReader(
+		xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Space: nsRetract, Local: "retract"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: id}},
+		}),
+		// The retraction has no body of its own, so make sure the server stores
+		// it in the archive for our other devices.
+		xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Space: "urn:xmpp:hints", Local: "store"},
+		}),
+	)
+}
+
+// Retract sends a retraction for a message that we sent earlier.
+// If we are offline it is sent when we next come online.
+func (c *Client) Retract(ctx context.Context, to jid.JID, typ stanza.MessageType, id string) (event.ChatMessage, error) {
+	msg := event.ChatMessage{
+		Message: stanza.Message{
+			To:   to,
+			Type: typ,
+		},
+		Body: c.p.Sprintf("This person attempted to retract a previous message, but it's unsupported by your client."),
+		Sent: true,
+		// The body is only a fallback for clients that don't support retraction.
+		Fallback: []event.Fallback{{For: nsRetract}},
+	}
+	msg.Retract.ID = id
+	return c.SendMessage(ctx, msg)
+}
+
+// nsCorrect is the namespace used by Last Message Correction (XEP-0308).
+const nsCorrect = "urn:xmpp:message-correct:0"
+
+func replaceToken(id string) xml.TokenReader {
+	if id == "" {
+		// Returns nil, EOF
+		return xmlstream.Token(nil)
+	}
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: nsCorrect, Local: "replace"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: id}},
+	})
+}
+
 // JoinMUC joins a multi-user chat, or rejoins it if it was already joined.
-func (c *Client) JoinMUC(ctx context.Context, room jid.JID) error {
+// Unless the options say otherwise, up to 100 messages of history are requested
+// when first joining the chat.
+// If the nickname is already taken, a few alternatives are tried before giving
+// up.
+func (c *Client) JoinMUC(ctx context.Context, room jid.JID, opts ...muc.Option) error {
 	s := room.Bare().String()
 	c.chanM.Lock()
 	defer c.chanM.Unlock()
 	mucChan, ok := c.channels[s]
 	if ok {
-		return mucChan.Join(ctx)
+		err := mucChan.Join(ctx, opts...)
+		if err != nil {
+			return err
+		}
+		c.joinedAt[s] = time.Now()
+		return nil
 	}
-	mucChan, err := c.mucClient.Join(ctx, room, c.Session, muc.MaxHistory(100))
+	opts = append([]muc.Option{muc.MaxHistory(100)}, opts...)
+	mucChan, err := c.joinFallback(ctx, room, opts...)
 	if err != nil {
 		return err
 	}
 	c.channels[s] = mucChan
+	c.joinOpts[s] = opts
+	c.joinedAt[s] = time.Now()
+	c.me.Store(s, mucChan.Me())
 	return nil
 }
 
+// isPrivate reports whether a message of the given type to or from j is a
+// private message with an occupant of a group chat that we have joined.
+func (c *Client) isPrivate(typ stanza.MessageType, j jid.JID) bool {
+	return typ != stanza.GroupChatMessage && j.Resourcepart() != "" && c.InMUC(j)
+}
+
+func privateToken(private bool) xml.TokenReader {
+	if !private {
+		// Returns nil, EOF
+		return xmlstream.Token(nil)
+	}
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: muc.NSUser, Local: "x"},
+	})
+}
+
+// InMUC reports whether we have joined the multi-user chat at room.
+func (c *Client) InMUC(room jid.JID) bool {
+	_, ok := c.me.Load(room.Bare().String())
+	return ok
+}
+
+// isMe reports whether j is our own occupant JID in a joined group chat.
+func (c *Client) isMe(j jid.JID) bool {
+	me, ok := c.me.Load(j.Bare().String())
+	return ok && me.(jid.JID).Equal(j)
+}
+
 // LeaveMUC exits the given multi-user chat..
 func (c *Client) LeaveMUC(ctx context.Context, room jid.JID, reason string) error {
 	s := room.Bare().String()
 	c.chanM.Lock()
-	defer c.chanM.Unlock()
 	mucChan, ok := c.channels[s]
+	c.chanM.Unlock()
 	if !ok {
 		return nil
 	}
+	// Our unavailable presence is only passed on to the MUC client while we are
+	// waiting for it here.
+	c.leaving.Store(s, struct{}{})
 	err := mucChan.Leave(ctx, reason)
+	c.leaving.Delete(s)
 	if err != nil {
 		return err
 	}
-	delete(c.channels, s)
+	c.forgetMUC(room)
 	return nil
 }
 
+// forgetMUC stops treating the given multi-user chat as joined.
+func (c *Client) forgetMUC(room jid.JID) {
+	s := room.Bare().String()
+	c.chanM.Lock()
+	defer c.chanM.Unlock()
+	delete(c.channels, s)
+	delete(c.joinOpts, s)
+	delete(c.joinedAt, s)
+	c.me.Delete(s)
+	c.realJIDs.Range(func(k, _ interface{}) bool {
+		if j, err := jid.Parse(k.(string)); err == nil && j.Bare().String() == s {
+			c.realJIDs.Delete(k)
+		}
+		return true
+	})
+}
+
 // Upload HTTP-uploads a file specified by path to the service specified by jid
 // and returns the GET URL.
 func (c *Client) Upload(ctx context.Context, path string, jid jid.JID) (string, error) {
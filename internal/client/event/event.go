@@ -6,15 +6,27 @@
 package event // import "mellium.im/communique/internal/client/event"
 
 import (
+	"time"
+
 	"mellium.im/xmpp/bookmarks"
 	"mellium.im/xmpp/delay"
 	"mellium.im/xmpp/disco"
 	"mellium.im/xmpp/forward"
 	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
 	"mellium.im/xmpp/roster"
 	"mellium.im/xmpp/stanza"
 )
 
+// Chat states defined by Chat State Notifications (XEP-0085).
+const (
+	StateActive    = "active"
+	StateComposing = "composing"
+	StatePaused    = "paused"
+	StateInactive  = "inactive"
+	StateGone      = "gone"
+)
+
 type (
 	// StatusOnline is sent when the user should come online.
 	StatusOnline jid.JID
@@ -28,6 +40,10 @@ type (
 	// StatusBusy is sent when the user should change their status to busy.
 	StatusBusy jid.JID
 
+	// Reconnecting is sent while waiting to reconnect after the connection was
+	// lost and contains the time remaining until the next attempt.
+	Reconnecting time.Duration
+
 	// FetchRoster is sent when a roster is fetched.
 	FetchRoster struct {
 		Ver   string
@@ -59,13 +75,111 @@ type (
 		OriginID stanza.OriginID `xml:"urn:xmpp:sid:0 origin-id"`
 		SID      []stanza.ID     `xml:"urn:xmpp:sid:0 stanza-id"`
 		Delay    delay.Delay     `xml:"urn:xmpp:delay delay"`
+		// Replace is set if this message is a correction of an earlier message
+		// (XEP-0308).
+		Replace struct {
+			ID string `xml:"id,attr"`
+		} `xml:"urn:xmpp:message-correct:0 replace"`
+		// Retract is set if this message retracts an earlier message (XEP-0424).
+		// If it was sent by a group chat on behalf of a moderator (XEP-0425), the ID
+		// is the stanza ID assigned by the group chat.
+		Retract struct {
+			ID string `xml:"id,attr"`
+		} `xml:"urn:xmpp:message-retract:1 retract"`
+		// Reactions is set if this message updates the sender's reactions to an
+		// earlier message (XEP-0444).
+		// In group chats the ID is the stanza ID assigned by the group chat.
+		Reactions struct {
+			ID        string   `xml:"id,attr"`
+			Reactions []string `xml:"reaction"`
+		} `xml:"urn:xmpp:reactions:0 reactions"`
+		// Reply is set if this message is a reply to an earlier message
+		// (XEP-0461).
+		// In group chats the ID is the stanza ID assigned by the group chat.
+		Reply struct {
+			To jid.JID `xml:"to,attr,omitempty"`
+			ID string  `xml:"id,attr"`
+		} `xml:"urn:xmpp:reply:0 reply"`
+		// Markable is set if the sender wants to receive chat markers for this
+		// message (XEP-0333).
+		Markable *struct{} `xml:"urn:xmpp:chat-markers:0 markable"`
+		// Marker is set if this message is a chat marker showing that messages up
+		// to and including the one with the given ID were displayed (XEP-0333).
+		// In group chats the ID is the stanza ID assigned by the group chat.
+		Marker struct {
+			ID string `xml:"id,attr"`
+		} `xml:"urn:xmpp:chat-markers:0 displayed"`
+		// Fallback marks parts of the body that are only included for clients
+		// that don't support some other feature (XEP-0428).
+		Fallback []Fallback `xml:"urn:xmpp:fallback:0 fallback"`
+		// Private is set on private messages sent to or received from an
+		// occupant of a group chat (XEP-0045).
+		Private *struct{} `xml:"http://jabber.org/protocol/muc#user x"`
 
-		// Sent is true if this message is one that we sent from another device (for
-		// example, a message forwarded to us by message carbons).
+		// Sent is true if this message is one that we sent, either from this client
+		// or from another device (for example, a message forwarded to us by message
+		// carbons).
 		Sent bool `xml:"-"`
 		// Account is true if this message was sent by the server (empty from, or
 		// from matching the bare JID of the authenticated account).
 		Account bool `xml:"-"`
+		// ChatState is an optional chat state (eg. "active") to send along with
+		// the message.
+		// It is not set on received messages, see the ChatState event instead.
+		ChatState string `xml:"-"`
+		// Retracted is true if the message was retracted and only a tombstone
+		// remains.
+		Retracted bool `xml:"-"`
+		// Quoted is the message that this message replies to, if it is known.
+		Quoted *ChatMessage `xml:"-"`
+		// Displayed is true if the message has been displayed by the recipient.
+		Displayed bool `xml:"-"`
+		// Received is true if we sent the message and a delivery receipt was
+		// received for it.
+		Received bool `xml:"-"`
+		// Failed is true if we sent the message and an error was returned instead.
+		Failed bool `xml:"-"`
+		// RealJID is the real address of the group chat occupant that sent the
+		// message, if the group chat exposes it.
+		RealJID jid.JID `xml:"-"`
+	}
+
+	// MessageFailed is sent when an error is returned for a message that we
+	// sent.
+	MessageFailed struct {
+		stanza.Message
+		Err stanza.Error `xml:"error"`
+	}
+
+	// Fallback is a fallback indication (XEP-0428) for the feature with the
+	// namespace For.
+	// If Body is empty, the entire body is a fallback.
+	Fallback struct {
+		For  string         `xml:"for,attr"`
+		Body []FallbackBody `xml:"body"`
+	}
+
+	// FallbackBody is a range of the body that is a fallback.
+	// Start and End are offsets in Unicode code points.
+	FallbackBody struct {
+		Start int `xml:"start,attr"`
+		End   int `xml:"end,attr"`
+	}
+
+	// Reactions is sent when reactions to a message are received (XEP-0444).
+	// They replace any earlier reactions to the same message from the same
+	// sender.
+	Reactions ChatMessage
+
+	// ChatMarker is sent when a displayed chat marker is received (XEP-0333),
+	// either from the person we're chatting with or from one of our own devices.
+	ChatMarker ChatMessage
+
+	// ChatState is sent when a chat state notification (eg. "composing" or
+	// "paused") is received.
+	ChatState struct {
+		From  jid.JID
+		State string
 	}
 
 	// HistoryMessage is sent on incoming messages resulting from a history query.
@@ -81,6 +195,53 @@ type (
 		} `xml:"urn:xmpp:mam:2 result"`
 	}
 
+	// Invitation is sent when we are invited to a group chat.
+	// Direct is true if the invitation was sent to us by the person inviting us
+	// instead of through the group chat, in which case it can't be declined.
+	Invitation struct {
+		Room     jid.JID
+		From     jid.JID
+		Reason   string
+		Password string
+		Direct   bool
+	}
+
+	// ChannelCreated is sent when joining a group chat created it.
+	// The group chat is locked until it is configured or the default
+	// configuration is accepted.
+	ChannelCreated jid.JID
+
+	// ChannelDisconnected is sent when we find out that we are no longer joined
+	// to a group chat that we did not leave (eg. because the group chat service
+	// was restarted) and are about to try rejoining it.
+	ChannelDisconnected jid.JID
+
+	// ChannelRejoined is sent when we rejoin a group chat that we were
+	// disconnected from.
+	ChannelRejoined jid.JID
+
+	// Occupant is sent when the presence of an occupant of a group chat that we
+	// have joined changes (eg. they join or leave, or their role changes).
+	// If the occupant changed their nickname, Left is true and the Nick of the
+	// item is their new nickname.
+	Occupant struct {
+		muc.Item
+
+		// Addr is the address of the occupant in the group chat (the address of
+		// the chat with their nickname as the resourcepart).
+		Addr jid.JID
+
+		// Show is the availability of the occupant (eg. "away"), or empty if they
+		// are online.
+		Show string
+
+		// Left is true if the occupant left the group chat.
+		Left bool
+
+		// Self is true if the occupant is us.
+		Self bool
+	}
+
 	// Receipt is sent when a message receipt is received and represents the ID of
 	// the message that should be marked as received.
 	// It may be sent by itself, or in addition to a ChatMessage event (or others)
@@ -102,3 +263,18 @@ type (
 		}
 	}
 )
+
+// With returns the address of the conversation that the message belongs to.
+// This is the bare JID of the contact or group chat that the message was sent
+// to or received from, except for private messages in a group chat which
+// belong to a conversation with the full address of the occupant.
+func (m ChatMessage) With() jid.JID {
+	j := m.From
+	if m.Sent {
+		j = m.To
+	}
+	if m.Private != nil && m.Type != stanza.GroupChatMessage {
+		return j
+	}
+	return j.Bare()
+}
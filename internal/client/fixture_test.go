@@ -0,0 +1,80 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stream"
+)
+
+// newTestClient returns a client that is logged in as hag66@example.net/pda
+// and passes events to handler.
+func newTestClient(t *testing.T, handler func(interface{})) *Client {
+	t.Helper()
+	session, err := xmpp.NewSession(context.Background(), jid.MustParse("example.net"), jid.MustParse("hag66@example.net/pda"), struct {
+		io.Reader
+		io.Writer
+	}{
+		Reader: strings.NewReader(""),
+		Writer: io.Discard,
+	}, 0, func(context.Context, *stream.Info, *stream.Info, *xmpp.Session, interface{}) (xmpp.SessionState, io.ReadWriter, interface{}, error) {
+		return xmpp.Ready, nil, nil, nil
+	})
+	if err != nil {
+		t.Fatalf("error creating session: %v", err)
+	}
+	return &Client{
+		Session:   session,
+		handler:   handler,
+		mucClient: &muc.Client{},
+	}
+}
+
+// handleStanza passes the stanza in to a mux built from opts.
+func handleStanza(t *testing.T, in string, opts ...mux.Option) {
+	t.Helper()
+	err := serveStanza(in, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serveStanza is like handleStanza except that it returns any errors instead of
+// failing the test so that it can be used from other goroutines.
+func serveStanza(in string, opts ...mux.Option) error {
+	return serveHandler(in, mux.New("jabber:client", opts...))
+}
+
+// serveHandler is like serveStanza except that the stanza is passed to h.
+func serveHandler(in string, h xmpp.Handler) error {
+	d := xml.NewDecoder(strings.NewReader(in))
+	tok, err := d.Token()
+	if err != nil {
+		return fmt.Errorf("error popping start token: %w", err)
+	}
+	start := tok.(xml.StartElement)
+	err = h.HandleXMPP(struct {
+		xml.TokenReader
+		io.Writer
+		*xml.Encoder
+	}{
+		TokenReader: d,
+		Encoder:     xml.NewEncoder(io.Discard),
+	}, &start)
+	if err != nil {
+		return fmt.Errorf("error handling %s: %w", start.Name.Local, err)
+	}
+	return nil
+}
@@ -15,7 +15,6 @@ import (
 	"mellium.im/xmpp/disco"
 	"mellium.im/xmpp/history"
 	"mellium.im/xmpp/jid"
-	"mellium.im/xmpp/muc"
 	"mellium.im/xmpp/mux"
 	"mellium.im/xmpp/receipts"
 	"mellium.im/xmpp/roster"
@@ -24,8 +23,7 @@ import (
 
 func newXMPPHandler(c *Client) xmpp.Handler {
 	msgHandler := newMessageHandler(c)
-	return mux.New(
-		c.In().XMLNS,
+	opts := []mux.Option{
 		disco.Handle(),
 		disco.HandleCaps(func(p stanza.Presence, caps disco.Caps) {
 			c.handler(event.NewCaps{
@@ -33,8 +31,6 @@ func newXMPPHandler(c *Client) xmpp.Handler {
 				Caps: caps,
 			})
 		}),
-		muc.HandleClient(c.mucClient),
-		// TODO: direct muc invitations.
 		roster.Handle(roster.Handler{
 			Push: func(ver string, item roster.Item) error {
 				c.rosterVer = ver
@@ -50,6 +46,14 @@ func newXMPPHandler(c *Client) xmpp.Handler {
 				if err != nil {
 					return err
 				}
+				switch {
+				case e.Reactions.ID != "":
+					c.handler(event.Reactions(e))
+					return nil
+				case e.Marker.ID != "":
+					c.handler(event.ChatMarker(e))
+					return nil
+				}
 				c.handler(e)
 				return nil
 			},
@@ -58,9 +62,18 @@ func newXMPPHandler(c *Client) xmpp.Handler {
 		mux.Message(stanza.NormalMessage, xml.Name{Local: "body"}, msgHandler),
 		mux.Message(stanza.ChatMessage, xml.Name{Local: "body"}, msgHandler),
 		mux.Message(stanza.GroupChatMessage, xml.Name{Local: "body"}, msgHandler),
+		mux.Message(stanza.ChatMessage, xml.Name{Space: nsRetract, Local: "retract"}, newRetractHandler(c)),
+		mux.Message(stanza.GroupChatMessage, xml.Name{Space: nsRetract, Local: "retract"}, newRetractHandler(c)),
+		mux.Message(stanza.ErrorMessage, xml.Name{Local: "error"}, newMessageErrorHandler(c)),
 		receipts.Handle(c.receiptsHandler),
 		history.Handle(history.NewHandler(newHistoryHandler(c))),
-	)
+	}
+	opts = append(opts, handleChatStates(c)...)
+	opts = append(opts, handleReactions(c)...)
+	opts = append(opts, handleMarkers(c)...)
+	opts = append(opts, handleOccupants(c)...)
+	opts = append(opts, handleInvites(c)...)
+	return mux.New(c.In().XMLNS, opts...)
 }
 
 func newPresenceHandler(c *Client) mux.PresenceHandlerFunc {
@@ -120,17 +133,83 @@ func newPresenceHandler(c *Client) mux.PresenceHandlerFunc {
 
 func newMessageHandler(c *Client) mux.MessageHandlerFunc {
 	return func(_ stanza.Message, r xmlstream.TokenReadEncoder) error {
-		msg := event.ChatMessage{}
+		toks, err := readStanza(r)
+		if err != nil || toks == nil {
+			return err
+		}
+		invite, err := isInvite(toks)
+		if err != nil || invite {
+			return err
+		}
 
-		d := xml.NewTokenDecoder(r)
-		err := d.Decode(&msg)
+		msg := event.ChatMessage{}
+		err = xml.NewTokenDecoder(tokenSlice(toks)).Decode(&msg)
 		if err != nil {
 			return err
 		}
+		// Retractions and reactions may include a fallback body, but they are
+		// handled by their own handlers.
+		if msg.Retract.ID != "" || msg.Reactions.ID != "" {
+			return nil
+		}
 		fromBare := msg.From.Bare()
 		if fromBare.Equal(jid.JID{}) || fromBare.Equal(c.LocalAddr().Bare()) {
 			msg.Account = true
 		}
+		switch {
+		case msg.Type == stanza.GroupChatMessage && c.isMe(msg.From):
+			// Our own messages are reflected back to us by the group chat with the
+			// stanza ID that it assigned, which is needed to retract them later.
+			msg.Sent = true
+			msg.To = msg.From.Bare()
+			msg.From = c.LocalAddr()
+		case c.isPrivate(msg.Type, msg.From):
+			// Not every group chat marks private messages as such.
+			msg.Private = &struct{}{}
+		case msg.Type == stanza.GroupChatMessage:
+			// Remember who sent the message so that only they can correct it.
+			if realJID, ok := c.realJIDs.Load(msg.From.String()); ok {
+				msg.RealJID = realJID.(jid.JID)
+			}
+		}
+		c.handler(msg)
+		return nil
+	}
+}
+
+func newRetractHandler(c *Client) mux.MessageHandlerFunc {
+	return func(_ stanza.Message, r xmlstream.TokenReadEncoder) error {
+		msg := event.ChatMessage{}
+
+		d := xml.NewTokenDecoder(r)
+		err := d.Decode(&msg)
+		if err != nil {
+			return err
+		}
+		switch {
+		case msg.Type == stanza.GroupChatMessage && c.isMe(msg.From):
+			// Our own retractions are reflected back to us by the group chat, so
+			// treat them the same as retractions sent from this client.
+			msg.Sent = true
+			msg.To = msg.From.Bare()
+			msg.From = c.LocalAddr()
+		case c.isPrivate(msg.Type, msg.From):
+			msg.Private = &struct{}{}
+		}
+		c.handler(msg)
+		return nil
+	}
+}
+
+func newMessageErrorHandler(c *Client) mux.MessageHandlerFunc {
+	return func(_ stanza.Message, r xmlstream.TokenReadEncoder) error {
+		msg := event.MessageFailed{}
+
+		d := xml.NewTokenDecoder(r)
+		err := d.Decode(&msg)
+		if err != nil {
+			return err
+		}
 		c.handler(msg)
 		return nil
 	}
@@ -146,7 +225,14 @@ func newHistoryHandler(c *Client) mux.MessageHandlerFunc {
 		if err != nil {
 			return err
 		}
-		if !msg.From.Equal(jid.JID{}) && !msg.From.Equal(c.LocalAddr().Bare()) {
+		// History comes from our own archive or from the archive of a group chat
+		// that we've joined.
+		archive := c.LocalAddr().Bare()
+		switch {
+		case msg.From.Equal(jid.JID{}) || msg.From.Equal(archive):
+		case msg.From.Resourcepart() == "" && c.InMUC(msg.From):
+			archive = msg.From
+		default:
 			c.debug.Print(p.Sprintf("possibly spoofed history message from %s", msg.From))
 			return nil
 		}
@@ -155,7 +241,22 @@ func newHistoryHandler(c *Client) mux.MessageHandlerFunc {
 			msg.Result.Forward.Msg.Account = true
 		}
 		msg.Result.Forward.Msg.Sent = fromBare.Equal(c.LocalAddr().Bare())
+		if fwd := &msg.Result.Forward.Msg; archive.Equal(fwd.From.Bare()) && c.isMe(fwd.From) {
+			// In the archive of a group chat our own messages are from our occupant
+			// JID, so treat them the same as messages sent from this client.
+			fwd.Sent = true
+			fwd.To = fwd.From.Bare()
+			fwd.From = c.LocalAddr()
+		}
 		msg.Result.Forward.Msg.Delay = msg.Result.Forward.Delay
+		// The result ID is the stanza ID that the archive assigned to the message
+		// so record it so that we can tell when we've already stored the message.
+		if msg.Result.ID != "" {
+			msg.Result.Forward.Msg.SID = append(msg.Result.Forward.Msg.SID, stanza.ID{
+				ID: msg.Result.ID,
+				By: archive,
+			})
+		}
 		c.handler(msg)
 		return nil
 	}
@@ -0,0 +1,143 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"encoding/xml"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// handleInvites returns mux options that emit Invitation events for direct
+// invitations (sent by the person inviting us) and mediated invitations (sent
+// through the group chat).
+// We don't use muc.HandleInvite because it doesn't tell us who sent the
+// invitation.
+func handleInvites(c *Client) []mux.Option {
+	direct := mux.MessageHandlerFunc(func(m stanza.Message, r xmlstream.TokenReadEncoder) error {
+		msg := struct {
+			stanza.Message
+			X muc.Invitation `xml:"jabber:x:conference x"`
+		}{}
+		err := xml.NewTokenDecoder(r).Decode(&msg)
+		if err != nil {
+			return err
+		}
+		if msg.X.JID.Equal(jid.JID{}) {
+			return nil
+		}
+		c.handler(event.Invitation{
+			Room:     msg.X.JID.Bare(),
+			From:     m.From,
+			Reason:   msg.X.Reason,
+			Password: msg.X.Password,
+			Direct:   true,
+		})
+		return nil
+	})
+	mediated := mux.MessageHandlerFunc(func(m stanza.Message, r xmlstream.TokenReadEncoder) error {
+		// The invitation is decoded by muc.Invitation, but it only knows about
+		// invitations that we send so we need to decode who it's from ourselves.
+		toks, err := readStanza(r)
+		if err != nil {
+			return err
+		}
+		msg := struct {
+			stanza.Message
+			X muc.Invitation `xml:"http://jabber.org/protocol/muc#user x"`
+		}{}
+		err = xml.NewTokenDecoder(tokenSlice(toks)).Decode(&msg)
+		if err != nil {
+			return err
+		}
+		invite, err := decodeInvite(toks)
+		if err != nil {
+			return err
+		}
+		// Other payloads such as declined invitations are ignored.
+		if invite == nil {
+			return nil
+		}
+		c.handler(event.Invitation{
+			Room:     m.From.Bare(),
+			From:     invite.From,
+			Reason:   msg.X.Reason,
+			Password: msg.X.Password,
+		})
+		return nil
+	})
+	return []mux.Option{
+		mux.Message(stanza.NormalMessage, xml.Name{Space: muc.NSConf, Local: "x"}, direct),
+		mux.Message(stanza.NormalMessage, xml.Name{Space: muc.NSUser, Local: "x"}, mediated),
+	}
+}
+
+// inviteFrom is the part of a mediated invitation that muc.Invitation does not
+// decode.
+type inviteFrom struct {
+	From jid.JID `xml:"from,attr"`
+}
+
+// invitePayload is used to detect invitations in a message.
+type invitePayload struct {
+	stanza.Message
+	Direct   *struct{} `xml:"jabber:x:conference x"`
+	Mediated struct {
+		Invite *inviteFrom `xml:"invite"`
+	} `xml:"http://jabber.org/protocol/muc#user x"`
+}
+
+// decodeInvite returns the mediated invitation in the message toks, or nil if
+// there is none.
+func decodeInvite(toks []xml.Token) (*inviteFrom, error) {
+	var payload invitePayload
+	err := xml.NewTokenDecoder(tokenSlice(toks)).Decode(&payload)
+	return payload.Mediated.Invite, err
+}
+
+// isInvite reports whether the message toks contains a direct or mediated
+// invitation.
+// Invitations may have a body for clients that don't support them, but they
+// are handled by handleInvites and must not be shown as messages.
+func isInvite(toks []xml.Token) (bool, error) {
+	var payload invitePayload
+	err := xml.NewTokenDecoder(tokenSlice(toks)).Decode(&payload)
+	return payload.Direct != nil || payload.Mediated.Invite != nil, err
+}
+
+// Invite invites someone to a group chat that we have joined.
+// The invitation is sent through the group chat so that it can add them as a
+// member if the group chat is members-only.
+func (c *Client) Invite(ctx context.Context, room, to jid.JID, reason string) error {
+	mucChan, err := c.channel(room)
+	if err != nil {
+		return err
+	}
+	return mucChan.Invite(ctx, reason, to)
+}
+
+// DeclineInvite tells the person who sent us a mediated invitation that we
+// won't be joining the group chat.
+func (c *Client) DeclineInvite(ctx context.Context, room, from jid.JID) error {
+	return c.Send(ctx, stanza.Message{
+		To:   room.Bare(),
+		Type: stanza.NormalMessage,
+	}.Wrap(xmlstream.Wrap(
+		xmlstream.Wrap(
+			nil,
+			xml.StartElement{
+				Name: xml.Name{Local: "decline"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "to"}, Value: from.String()}},
+			},
+		),
+		xml.StartElement{Name: xml.Name{Space: muc.NSUser, Local: "x"}},
+	)))
+}
@@ -0,0 +1,86 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"strconv"
+	"testing"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/xmpp/jid"
+)
+
+var inviteTestCases = [...]struct {
+	in       string
+	expected []event.Invitation
+}{
+	0: {
+		in: `<message xmlns="jabber:client" from="juliet@example.net/balcony" to="romeo@example.net"><x xmlns="jabber:x:conference" jid="darkcave@chat.example.net" password="cauldronburn" reason="Hey Hecate, this is the place for all good witches!"/></message>`,
+		expected: []event.Invitation{{
+			Room:     jid.MustParse("darkcave@chat.example.net"),
+			From:     jid.MustParse("juliet@example.net/balcony"),
+			Reason:   "Hey Hecate, this is the place for all good witches!",
+			Password: "cauldronburn",
+			Direct:   true,
+		}},
+	},
+	1: {
+		in: `<message xmlns="jabber:client" from="coven@chat.example.net" to="hecate@example.net"><body>You have been invited</body><x xmlns="http://jabber.org/protocol/muc#user"><invite from="crone1@example.net/desktop"><reason>Hey Hecate, this is the place for all good witches!</reason></invite><password>cauldronburn</password></x></message>`,
+		expected: []event.Invitation{{
+			Room:     jid.MustParse("coven@chat.example.net"),
+			From:     jid.MustParse("crone1@example.net/desktop"),
+			Reason:   "Hey Hecate, this is the place for all good witches!",
+			Password: "cauldronburn",
+		}},
+	},
+	2: {
+		// Invitations with a body are not also messages.
+		in: `<message xmlns="jabber:client" from="juliet@example.net/balcony" to="romeo@example.net"><body>Join darkcave@chat.example.net</body><x xmlns="jabber:x:conference" jid="darkcave@chat.example.net"/></message>`,
+		expected: []event.Invitation{{
+			Room:   jid.MustParse("darkcave@chat.example.net"),
+			From:   jid.MustParse("juliet@example.net/balcony"),
+			Direct: true,
+		}},
+	},
+	3: {
+		// Declined invitations are not invitations.
+		in: `<message xmlns="jabber:client" from="coven@chat.example.net" to="crone1@example.net/desktop"><x xmlns="http://jabber.org/protocol/muc#user"><decline from="hecate@example.net"><reason>Sorry, I'm too busy right now.</reason></decline></x></message>`,
+	},
+}
+
+func TestInvites(t *testing.T) {
+	for i, tc := range inviteTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var invites []event.Invitation
+			var msgs int
+			c := newTestClient(t, func(v interface{}) {
+				switch e := v.(type) {
+				case event.Invitation:
+					invites = append(invites, e)
+				case event.ChatMessage:
+					msgs++
+				}
+			})
+			// Use the full handler so that we notice if the invitation is also
+			// handled as a message.
+			err := serveHandler(tc.in, newXMPPHandler(c))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msgs != 0 {
+				t.Errorf("invitation was also handled as %d messages", msgs)
+			}
+			if len(invites) != len(tc.expected) {
+				t.Fatalf("wrong number of invitations: want=%d, got=%d", len(tc.expected), len(invites))
+			}
+			for i, invite := range invites {
+				want := tc.expected[i]
+				if !invite.Room.Equal(want.Room) || !invite.From.Equal(want.From) || invite.Reason != want.Reason || invite.Password != want.Password || invite.Direct != want.Direct {
+					t.Errorf("wrong invitation: want=%+v, got=%+v", want, invite)
+				}
+			}
+		})
+	}
+}
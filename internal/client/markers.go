@@ -0,0 +1,91 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"encoding/xml"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// nsMarkers is the namespace used by Chat Markers (XEP-0333).
+const nsMarkers = "urn:xmpp:chat-markers:0"
+
+// MarkDisplayed tells the sender of a message, and our own other devices, that
+// every message up to and including the one with the given ID was displayed.
+// In group chats the ID must be the stanza ID assigned by the group chat.
+// If we are offline, nothing is sent.
+func (c *Client) MarkDisplayed(ctx context.Context, to jid.JID, typ stanza.MessageType, id string) error {
+	if !c.online {
+		return nil
+	}
+	return c.Send(ctx, stanza.Message{
+		To:   to,
+		Type: typ,
+	}.Wrap(xmlstream.MultiReader(
+		markerToken(id),
+		// Markers have no body, so make sure the server stores them in the archive
+		// for our other devices.
+		xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Space: "urn:xmpp:hints", Local: "store"},
+		}),
+	)))
+}
+
+func markerToken(id string) xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: nsMarkers, Local: "displayed"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: id}},
+	})
+}
+
+func markableToken(e event.ChatMessage) xml.TokenReader {
+	// Only messages with actual content should be marked as read, not things
+	// like retractions that only have a fallback body.
+	if e.Body == "" || e.Retract.ID != "" {
+		// Returns nil, EOF
+		return xmlstream.Token(nil)
+	}
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: nsMarkers, Local: "markable"},
+	})
+}
+
+// handleMarkers returns mux options that emit ChatMarker events for incoming
+// displayed markers.
+// In group chats only the markers that we sent from other devices are handled.
+func handleMarkers(c *Client) []mux.Option {
+	h := mux.MessageHandlerFunc(func(_ stanza.Message, r xmlstream.TokenReadEncoder) error {
+		msg := event.ChatMessage{}
+		d := xml.NewTokenDecoder(r)
+		err := d.Decode(&msg)
+		if err != nil {
+			return err
+		}
+		if msg.Type == stanza.GroupChatMessage {
+			if !c.isMe(msg.From) {
+				return nil
+			}
+			msg.Sent = true
+			msg.To = msg.From.Bare()
+			msg.From = c.LocalAddr()
+		}
+		if c.isPrivate(msg.Type, msg.From) {
+			msg.Private = &struct{}{}
+		}
+		c.handler(event.ChatMarker(msg))
+		return nil
+	})
+	name := xml.Name{Space: nsMarkers, Local: "displayed"}
+	return []mux.Option{
+		mux.Message(stanza.ChatMessage, name, h),
+		mux.Message(stanza.GroupChatMessage, name, h),
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+	"mellium.im/xmpp/stanza"
+)
+
+// errNotJoined is returned when trying to moderate a group chat that we have
+// not joined.
+var errNotJoined = errors.New("not joined to the group chat")
+
+// channel returns the group chat at room if we have joined it.
+func (c *Client) channel(room jid.JID) (*muc.Channel, error) {
+	c.chanM.Lock()
+	defer c.chanM.Unlock()
+	mucChan, ok := c.channels[room.Bare().String()]
+	if !ok {
+		return nil, errNotJoined
+	}
+	return mucChan, nil
+}
+
+// SetRole changes the role of the occupant with the given nickname in a group
+// chat that we have joined.
+// Setting the role to none kicks the occupant from the group chat, and setting
+// it to participant or visitor grants or revokes their voice.
+// If the group chat does not allow the change, the error returned is the
+// stanza.Error sent by the group chat.
+func (c *Client) SetRole(ctx context.Context, room jid.JID, nick string, role muc.Role, reason string) error {
+	mucChan, err := c.channel(room)
+	if err != nil {
+		return err
+	}
+	var reasonEl xml.TokenReader
+	if reason != "" {
+		reasonEl = xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(reason)),
+			xml.StartElement{Name: xml.Name{Local: "reason"}},
+		)
+	}
+	payload := xmlstream.Wrap(
+		xmlstream.Wrap(
+			reasonEl,
+			xml.StartElement{
+				Name: xml.Name{Local: "item"},
+				Attr: []xml.Attr{
+					{Name: xml.Name{Local: "nick"}, Value: nick},
+					{Name: xml.Name{Local: "role"}, Value: role.String()},
+				},
+			},
+		),
+		xml.StartElement{Name: xml.Name{Space: muc.NSAdmin, Local: "query"}},
+	)
+	return c.UnmarshalIQElement(ctx, payload, stanza.IQ{
+		Type: stanza.SetIQ,
+		To:   mucChan.Addr().Bare(),
+	}, nil)
+}
+
+// SetAffiliation changes the affiliation of a user (identified by their real
+// JID) to a group chat that we have joined.
+// Setting the affiliation to outcast bans the user from the group chat.
+// If the group chat does not allow the change, the error returned is the
+// stanza.Error sent by the group chat.
+func (c *Client) SetAffiliation(ctx context.Context, room, j jid.JID, a muc.Affiliation, reason string) error {
+	mucChan, err := c.channel(room)
+	if err != nil {
+		return err
+	}
+	return mucChan.SetAffiliation(ctx, a, j, "", reason)
+}
@@ -0,0 +1,103 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+	"mellium.im/xmpp/stanza"
+)
+
+// maxNickFallbacks is the number of alternative nicknames that are tried when
+// the nickname we join a group chat with is already taken.
+const maxNickFallbacks = 3
+
+// fallbackNick returns the nickname to try when nick is already taken in a
+// group chat.
+func fallbackNick(nick string) string {
+	return nick + "_"
+}
+
+// isNickConflict reports whether err was returned because our nickname is
+// already taken in the group chat.
+func isNickConflict(err error) bool {
+	return errors.Is(err, stanza.Error{Condition: stanza.Conflict})
+}
+
+// joinFallback joins a group chat, trying alternative nicknames if the one in
+// room is already taken.
+func (c *Client) joinFallback(ctx context.Context, room jid.JID, opts ...muc.Option) (*muc.Channel, error) {
+	p := c.Printer()
+	for i := 0; ; i++ {
+		mucChan, err := c.mucClient.Join(ctx, room, c.Session, opts...)
+		if err == nil || i == maxNickFallbacks || !isNickConflict(err) {
+			return mucChan, err
+		}
+		c.debug.Print(p.Sprintf("nickname %q is taken in %s, trying another", room.Resourcepart(), room.Bare()))
+		room, err = room.WithResource(fallbackNick(room.Resourcepart()))
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ChangeNick changes our nickname in a group chat that we have joined.
+// If the nickname is taken or not allowed, the error returned is the
+// stanza.Error sent by the group chat.
+func (c *Client) ChangeNick(ctx context.Context, room jid.JID, nick string) error {
+	s := room.Bare().String()
+	addr, err := room.Bare().WithResource(nick)
+	if err != nil {
+		return err
+	}
+	c.chanM.Lock()
+	_, ok := c.channels[s]
+	c.chanM.Unlock()
+	if !ok {
+		return errNotJoined
+	}
+
+	// A nickname change is plain available presence sent to our new occupant JID
+	// (XEP-0045 §7.6).
+	// The group chat answers with unavailable presence for our old nickname
+	// (which updates our occupant JID, see handleOccupants) and available
+	// presence for the new one, or with an error.
+	resp, err := c.SendPresenceElement(ctx, nil, stanza.Presence{
+		ID: randomID(),
+		To: addr,
+	})
+	if err != nil {
+		// Not every group chat includes our ID in its response, so if we've
+		// already seen the nickname change don't treat the timeout as an error.
+		if ctx.Err() != nil && c.isMe(addr) {
+			return nil
+		}
+		return err
+	}
+	/* #nosec */
+	defer resp.Close()
+	reply := struct {
+		stanza.Presence
+		Err *stanza.Error `xml:"error"`
+	}{}
+	err = xml.NewTokenDecoder(resp).Decode(&reply)
+	if err != nil {
+		return err
+	}
+	if reply.Type == stanza.ErrorPresence {
+		if reply.Err == nil {
+			return stanza.Error{}
+		}
+		return *reply.Err
+	}
+	// The response is not passed on to handleOccupants, so if it was the
+	// presence that changed our nickname we need to record it ourselves.
+	c.me.Store(s, addr)
+	return nil
+}
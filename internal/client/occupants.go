@@ -0,0 +1,156 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"encoding/xml"
+	"io"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// statusSelf is the MUC status code that marks presence as being about
+// ourselves.
+const statusSelf = 110
+
+// statusNickChange is the MUC status code that is sent with unavailable
+// presence when an occupant changes their nickname.
+const statusNickChange = 303
+
+// occupantPresence is a presence from a group chat occupant.
+type occupantPresence struct {
+	stanza.Presence
+	Show string `xml:"show"`
+	X    struct {
+		Item   muc.Item `xml:"item"`
+		Status []struct {
+			Code int `xml:"code,attr"`
+		} `xml:"status"`
+	} `xml:"http://jabber.org/protocol/muc#user x"`
+}
+
+func (p occupantPresence) hasStatus(code int) bool {
+	for _, status := range p.X.Status {
+		if status.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// handleOccupants returns mux options that emit Occupant events for presence
+// from group chat occupants and then pass our own presence on to the MUC client
+// (which only keeps track of our own presence in the chat).
+func handleOccupants(c *Client) []mux.Option {
+	h := mux.PresenceHandlerFunc(func(p stanza.Presence, r xmlstream.TokenReadEncoder) error {
+		// Both us and the MUC client need to decode the presence, so buffer it.
+		toks, err := readStanza(r)
+		if err != nil || toks == nil {
+			return err
+		}
+
+		var occupant occupantPresence
+		err = xml.NewTokenDecoder(tokenSlice(toks)).Decode(&occupant)
+		if err != nil {
+			return err
+		}
+		item := occupant.X.Item
+		switch {
+		case p.Type == stanza.UnavailablePresence:
+			c.realJIDs.Delete(p.From.String())
+		case !item.JID.Equal(jid.JID{}):
+			c.realJIDs.Store(p.From.String(), item.JID.Bare())
+		}
+		if p.Type == stanza.UnavailablePresence && !occupant.hasStatus(statusNickChange) {
+			item.Nick = ""
+		}
+		c.handler(event.Occupant{
+			Item: item,
+			Addr: p.From,
+			Show: occupant.Show,
+			Left: p.Type == stanza.UnavailablePresence,
+			Self: occupant.hasStatus(statusSelf),
+		})
+		if !occupant.hasStatus(statusSelf) {
+			// The MUC client only keeps track of our own presence.
+			return nil
+		}
+		room := p.From.Bare()
+		switch {
+		case p.Type == stanza.UnavailablePresence && occupant.hasStatus(statusNickChange):
+			// Our nickname was changed, either by us or by the group chat.
+			// Don't pass this on to the MUC client since it would treat it as us
+			// leaving the group chat.
+			if me, err := room.WithResource(occupant.X.Item.Nick); err == nil {
+				c.me.Store(room.String(), me)
+			}
+			return nil
+		case p.Type == stanza.UnavailablePresence:
+			if _, ok := c.leaving.Load(room.String()); ok {
+				// LeaveMUC is waiting for the MUC client to see that we left.
+				break
+			}
+			// We were removed from the group chat (eg. we were kicked or it was
+			// destroyed).
+			// Stop treating it as joined so that we don't try to rejoin, and don't
+			// pass it on to the MUC client which would block until someone called
+			// Leave.
+			c.forgetMUC(room)
+			return nil
+		case occupant.hasStatus(statusCreated):
+			c.handler(event.ChannelCreated(room))
+		}
+
+		return c.mucClient.HandlePresence(p, struct {
+			xml.TokenReader
+			xmlstream.Encoder
+		}{
+			TokenReader: tokenSlice(toks),
+			Encoder:     r,
+		})
+	})
+	userPresence := xml.Name{Space: muc.NSUser, Local: "x"}
+	return []mux.Option{
+		mux.Presence(stanza.AvailablePresence, userPresence, h),
+		mux.Presence(stanza.UnavailablePresence, userPresence, h),
+	}
+}
+
+// readStanza buffers the stanza read from r so that it can be decoded more than
+// once.
+// If r does not start with a stanza nil is returned.
+func readStanza(r xml.TokenReader) ([]xml.Token, error) {
+	tok, err := r.Token()
+	if err != nil {
+		return nil, err
+	}
+	start, ok := tok.(xml.StartElement)
+	if !ok {
+		return nil, nil
+	}
+	start = start.Copy()
+	return xmlstream.ReadAll(xmlstream.MultiReader(
+		xmlstream.Token(start),
+		xmlstream.Inner(r),
+		xmlstream.Token(start.End()),
+	))
+}
+
+// tokenSlice returns a token reader that reads from a slice of tokens.
+func tokenSlice(toks []xml.Token) xml.TokenReader {
+	return xmlstream.ReaderFunc(func() (xml.Token, error) {
+		if len(toks) == 0 {
+			return nil, io.EOF
+		}
+		tok := toks[0]
+		toks = toks[1:]
+		return tok, nil
+	})
+}
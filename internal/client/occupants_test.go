@@ -0,0 +1,188 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+)
+
+var occupantTestCases = [...]struct {
+	in       string
+	expected event.Occupant
+	me       string
+}{
+	0: {
+		in: `<presence xmlns="jabber:client" from="coven@chat.example.net/thirdwitch" to="hag66@example.net/pda"><x xmlns="http://jabber.org/protocol/muc#user"><item affiliation="member" role="participant"/></x></presence>`,
+		expected: event.Occupant{
+			Item: muc.Item{Affiliation: muc.AffiliationMember, Role: muc.RoleParticipant},
+			Addr: jid.MustParse("coven@chat.example.net/thirdwitch"),
+		},
+		me: "coven@chat.example.net/firstwitch",
+	},
+	1: {
+		// Our nickname was changed.
+		in: `<presence xmlns="jabber:client" type="unavailable" from="coven@chat.example.net/firstwitch" to="hag66@example.net/pda"><x xmlns="http://jabber.org/protocol/muc#user"><item affiliation="member" nick="oldhag" role="participant"/><status code="303"/><status code="110"/></x></presence>`,
+		expected: event.Occupant{
+			Item: muc.Item{Affiliation: muc.AffiliationMember, Nick: "oldhag", Role: muc.RoleParticipant},
+			Addr: jid.MustParse("coven@chat.example.net/firstwitch"),
+			Left: true,
+			Self: true,
+		},
+		me: "coven@chat.example.net/oldhag",
+	},
+	2: {
+		// We were kicked.
+		in: `<presence xmlns="jabber:client" type="unavailable" from="coven@chat.example.net/firstwitch" to="hag66@example.net/pda"><x xmlns="http://jabber.org/protocol/muc#user"><item affiliation="none" role="none"><reason>Avaunt, you cullion!</reason></item><status code="307"/><status code="110"/></x></presence>`,
+		expected: event.Occupant{
+			Item: muc.Item{Reason: "Avaunt, you cullion!"},
+			Addr: jid.MustParse("coven@chat.example.net/firstwitch"),
+			Left: true,
+			Self: true,
+		},
+	},
+}
+
+func TestOccupants(t *testing.T) {
+	for i, tc := range occupantTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var occupants []event.Occupant
+			c := newTestClient(t, func(v interface{}) {
+				if e, ok := v.(event.Occupant); ok {
+					occupants = append(occupants, e)
+				}
+			})
+			room := "coven@chat.example.net"
+			c.me.Store(room, jid.MustParse("coven@chat.example.net/firstwitch"))
+			handleStanza(t, tc.in, handleOccupants(c)...)
+			if len(occupants) != 1 {
+				t.Fatalf("wrong number of occupant events: want=1, got=%d", len(occupants))
+			}
+			o, want := occupants[0], tc.expected
+			if o.Nick != want.Nick || o.Role != want.Role || o.Affiliation != want.Affiliation || o.Reason != want.Reason || !o.Addr.Equal(want.Addr) || o.Left != want.Left || o.Self != want.Self {
+				t.Errorf("wrong occupant: want=%+v, got=%+v", want, o)
+			}
+			me, ok := c.me.Load(room)
+			switch {
+			case tc.me == "" && ok:
+				t.Errorf("still joined as %s after leaving", me)
+			case tc.me != "" && (!ok || !me.(jid.JID).Equal(jid.MustParse(tc.me))):
+				t.Errorf("wrong occupant JID: want=%s, got=%v", tc.me, me)
+			}
+		})
+	}
+}
+
+var removedTestCases = [...]string{
+	// We were kicked.
+	0: `<presence xmlns="jabber:client" type="unavailable" from="coven@chat.example.net/firstwitch" to="hag66@example.net/pda"><x xmlns="http://jabber.org/protocol/muc#user"><item affiliation="none" role="none"/><status code="307"/><status code="110"/></x></presence>`,
+	// The group chat was destroyed.
+	1: `<presence xmlns="jabber:client" type="unavailable" from="coven@chat.example.net/firstwitch" to="hag66@example.net/pda"><x xmlns="http://jabber.org/protocol/muc#user"><item affiliation="none" role="none"/><destroy jid="chamber@chat.example.org"><reason>Macbeth doth come.</reason></destroy><status code="110"/></x></presence>`,
+}
+
+func TestRemoved(t *testing.T) {
+	for i, in := range removedTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			c := newTestClient(t, func(interface{}) {})
+			room := jid.MustParse("coven@chat.example.net")
+			me := jid.MustParse("coven@chat.example.net/firstwitch")
+			// Make the MUC client manage the channel without waiting for the join
+			// to complete.
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			mucChan, _ := c.mucClient.Join(ctx, me, c.Session)
+			c.channels = map[string]*muc.Channel{room.String(): mucChan}
+			c.joinOpts = map[string][]muc.Option{room.String(): nil}
+			c.joinedAt = map[string]time.Time{room.String(): time.Now()}
+			c.me.Store(room.String(), me)
+
+			errs := make(chan error, 1)
+			go func() {
+				errs <- serveStanza(in, handleOccupants(c)...)
+			}()
+			select {
+			case err := <-errs:
+				if err != nil {
+					t.Fatal(err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("handling our own unavailable presence blocked")
+			}
+			if _, ok := c.channels[room.String()]; ok {
+				t.Errorf("still tracking the channel after being removed")
+			}
+			if _, ok := c.joinedAt[room.String()]; ok {
+				t.Errorf("still tracking the join time after being removed")
+			}
+			if c.InMUC(room) {
+				t.Errorf("still joined after being removed")
+			}
+		})
+	}
+}
+
+var realJIDTestCases = [...]struct {
+	in       []string
+	expected string
+}{
+	0: {
+		in: []string{
+			`<presence xmlns="jabber:client" from="coven@chat.example.net/thirdwitch" to="hag66@example.net/pda"><x xmlns="http://jabber.org/protocol/muc#user"><item affiliation="member" jid="crone@example.net/broom" role="participant"/></x></presence>`,
+			`<message xmlns="jabber:client" type="groupchat" from="coven@chat.example.net/thirdwitch" to="hag66@example.net/pda" id="1"><body>foo</body></message>`,
+		},
+		expected: "crone@example.net",
+	},
+	1: {
+		// The group chat doesn't expose real JIDs.
+		in: []string{
+			`<presence xmlns="jabber:client" from="coven@chat.example.net/thirdwitch" to="hag66@example.net/pda"><x xmlns="http://jabber.org/protocol/muc#user"><item affiliation="member" role="participant"/></x></presence>`,
+			`<message xmlns="jabber:client" type="groupchat" from="coven@chat.example.net/thirdwitch" to="hag66@example.net/pda" id="1"><body>foo</body></message>`,
+		},
+	},
+	2: {
+		// The occupant left and someone else took their nickname.
+		in: []string{
+			`<presence xmlns="jabber:client" from="coven@chat.example.net/thirdwitch" to="hag66@example.net/pda"><x xmlns="http://jabber.org/protocol/muc#user"><item affiliation="member" jid="crone@example.net/broom" role="participant"/></x></presence>`,
+			`<presence xmlns="jabber:client" type="unavailable" from="coven@chat.example.net/thirdwitch" to="hag66@example.net/pda"><x xmlns="http://jabber.org/protocol/muc#user"><item affiliation="member" role="none"/></x></presence>`,
+			`<message xmlns="jabber:client" type="groupchat" from="coven@chat.example.net/thirdwitch" to="hag66@example.net/pda" id="1"><body>foo</body></message>`,
+		},
+	},
+}
+
+func TestRealJID(t *testing.T) {
+	for i, tc := range realJIDTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var msgs []event.ChatMessage
+			c := newTestClient(t, func(v interface{}) {
+				if e, ok := v.(event.ChatMessage); ok {
+					msgs = append(msgs, e)
+				}
+			})
+			c.me.Store("coven@chat.example.net", jid.MustParse("coven@chat.example.net/firstwitch"))
+			for _, in := range tc.in {
+				err := serveHandler(in, newXMPPHandler(c))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(msgs) != 1 {
+				t.Fatalf("wrong number of messages: want=1, got=%d", len(msgs))
+			}
+			var expected jid.JID
+			if tc.expected != "" {
+				expected = jid.MustParse(tc.expected)
+			}
+			if !msgs[0].RealJID.Equal(expected) {
+				t.Errorf("wrong real JID: want=%s, got=%s", expected, msgs[0].RealJID)
+			}
+		})
+	}
+}
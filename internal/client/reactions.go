@@ -0,0 +1,88 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"encoding/xml"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// nsReactions is the namespace used by Message Reactions (XEP-0444).
+const nsReactions = "urn:xmpp:reactions:0"
+
+// React sets our reactions to the message with the given ID, replacing any
+// reactions that we sent earlier.
+// An empty list of reactions removes all of our reactions.
+// In group chats the ID must be the stanza ID assigned by the group chat.
+func (c *Client) React(ctx context.Context, to jid.JID, typ stanza.MessageType, id string, reactions []string) (event.ChatMessage, error) {
+	msg := event.ChatMessage{
+		Message: stanza.Message{
+			To:   to,
+			Type: typ,
+		},
+		Sent: true,
+	}
+	msg.Reactions.ID = id
+	msg.Reactions.Reactions = reactions
+	return c.SendMessage(ctx, msg)
+}
+
+func reactionsToken(id string, reactions []string) xml.TokenReader {
+	if id == "" {
+		// Returns nil, EOF
+		return xmlstream.Token(nil)
+	}
+	var inner []xml.TokenReader
+	for _, r := range reactions {
+		inner = append(inner, omitEmpty(r, xml.Name{Local: "reaction"}))
+	}
+	return xmlstream.MultiReader(
+		xmlstream.Wrap(xmlstream.MultiReader(inner...), xml.StartElement{
+			Name: xml.Name{Space: nsReactions, Local: "reactions"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: id}},
+		}),
+		// Reactions have no body, so make sure the server stores them in the
+		// archive for our other devices.
+		xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Space: "urn:xmpp:hints", Local: "store"},
+		}),
+	)
+}
+
+// handleReactions returns mux options that emit Reactions events for incoming
+// reactions in one-to-one and group chats.
+func handleReactions(c *Client) []mux.Option {
+	h := mux.MessageHandlerFunc(func(_ stanza.Message, r xmlstream.TokenReadEncoder) error {
+		msg := event.ChatMessage{}
+		d := xml.NewTokenDecoder(r)
+		err := d.Decode(&msg)
+		if err != nil {
+			return err
+		}
+		switch {
+		case msg.Type == stanza.GroupChatMessage && c.isMe(msg.From):
+			// Our own reactions are reflected back to us by the group chat, so treat
+			// them the same as reactions sent from this client.
+			msg.Sent = true
+			msg.To = msg.From.Bare()
+			msg.From = c.LocalAddr()
+		case c.isPrivate(msg.Type, msg.From):
+			msg.Private = &struct{}{}
+		}
+		c.handler(event.Reactions(msg))
+		return nil
+	})
+	name := xml.Name{Space: nsReactions, Local: "reactions"}
+	return []mux.Option{
+		mux.Message(stanza.ChatMessage, name, h),
+		mux.Message(stanza.GroupChatMessage, name, h),
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"mellium.im/communique/internal/client/event"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = 5 * time.Minute
+)
+
+// backoff returns how long to wait before the given reconnect attempt (starting
+// at 0) using exponential backoff with jitter.
+func backoff(attempt int) time.Duration {
+	d := maxBackoff
+	if attempt < 16 {
+		d = min(minBackoff<<attempt, maxBackoff)
+	}
+	// Randomize the second half of the delay so that lots of clients that were
+	// disconnected at the same time (eg. when a server restarts) don't all try
+	// to reconnect at once.
+	return d/2 + rand.N(d/2) // #nosec G404
+}
+
+// superviseReconnect attempts to bring the client back online with exponential
+// backoff until it succeeds or the user explicitly goes offline.
+// Only one reconnect loop is ever running at a time.
+func (c *Client) superviseReconnect() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.reconnectM.Lock()
+	if c.cancelReconnect != nil {
+		c.reconnectM.Unlock()
+		cancel()
+		return
+	}
+	c.cancelReconnect = cancel
+	c.reconnectCtx = ctx
+	c.reconnectM.Unlock()
+	defer c.endReconnect(ctx, cancel)
+
+	p := c.Printer()
+	for attempt := 0; ; attempt++ {
+		if !c.countdown(ctx, backoff(attempt)) {
+			return
+		}
+		c.debug.Print(p.Sprintf("reconnecting (attempt %d)…", attempt+1))
+		err := func() error {
+			reconnectCtx, reconnectCancel := context.WithTimeout(ctx, 3*c.timeout)
+			defer reconnectCancel()
+			return c.restoreStatus(reconnectCtx)
+		}()
+		if err == nil {
+			return
+		}
+		if ctx.Err() != nil || !c.reconnecting(ctx) {
+			// We were told to stop, or the connection was re-established and is now
+			// looked after by a new loop.
+			return
+		}
+		c.logger.Print(p.Sprintf("error reconnecting: %v", err))
+	}
+}
+
+// countdown waits for d, emitting a Reconnecting event every second.
+// It returns false if the context is canceled or we come back online some
+// other way before we finish waiting.
+func (c *Client) countdown(ctx context.Context, d time.Duration) bool {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	deadline := time.Now().Add(d)
+	for {
+		if !c.reconnecting(ctx) {
+			return false
+		}
+		remaining := time.Until(deadline).Round(time.Second)
+		if remaining <= 0 {
+			return true
+		}
+		c.handler(event.Reconnecting(remaining))
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// stopReconnecting cancels any running reconnect loop and reports whether one
+// was running.
+func (c *Client) stopReconnecting() bool {
+	c.reconnectM.Lock()
+	defer c.reconnectM.Unlock()
+	if c.cancelReconnect == nil {
+		return false
+	}
+	c.cancelReconnect()
+	c.cancelReconnect = nil
+	c.reconnectCtx = nil
+	return true
+}
+
+// reconnecting reports whether the reconnect loop with the given context is
+// still the current one.
+func (c *Client) reconnecting(ctx context.Context) bool {
+	c.reconnectM.Lock()
+	defer c.reconnectM.Unlock()
+	return c.reconnectCtx == ctx
+}
+
+// detachReconnect forgets the running reconnect loop (without canceling it)
+// so that a new one can be started if the connection drops again before it
+// returns.
+func (c *Client) detachReconnect() {
+	c.reconnectM.Lock()
+	defer c.reconnectM.Unlock()
+	c.cancelReconnect = nil
+	c.reconnectCtx = nil
+}
+
+// endReconnect cleans up after the reconnect loop with the given context
+// returns.
+func (c *Client) endReconnect(ctx context.Context, cancel context.CancelFunc) {
+	cancel()
+	c.reconnectM.Lock()
+	defer c.reconnectM.Unlock()
+	if c.reconnectCtx == ctx {
+		c.cancelReconnect = nil
+		c.reconnectCtx = nil
+	}
+}
+
+// restoreStatus reconnects and sets the last status that was selected by the
+// user.
+func (c *Client) restoreStatus(ctx context.Context) error {
+	c.reconnectM.Lock()
+	status := c.status
+	c.reconnectM.Unlock()
+	if status == nil {
+		status = c.Online
+	}
+	return status(ctx)
+}
+
+// setStatus remembers the last status selected so that it can be restored if
+// we have to reconnect.
+func (c *Client) setStatus(status func(context.Context) error) {
+	c.reconnectM.Lock()
+	defer c.reconnectM.Unlock()
+	c.status = status
+}
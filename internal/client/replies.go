@@ -0,0 +1,56 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"encoding/xml"
+	"strconv"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+)
+
+// NSReply is the namespace used by Message Replies (XEP-0461).
+const NSReply = "urn:xmpp:reply:0"
+
+// nsFallback is the namespace used by Fallback Indication (XEP-0428).
+const nsFallback = "urn:xmpp:fallback:0"
+
+func replyToken(to jid.JID, id string) xml.TokenReader {
+	if id == "" {
+		// Returns nil, EOF
+		return xmlstream.Token(nil)
+	}
+	attrs := []xml.Attr{{Name: xml.Name{Local: "id"}, Value: id}}
+	if !to.Equal(jid.JID{}) {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "to"}, Value: to.String()})
+	}
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NSReply, Local: "reply"},
+		Attr: attrs,
+	})
+}
+
+func fallbackToken(fallbacks []event.Fallback) xml.TokenReader {
+	var inner []xml.TokenReader
+	for _, f := range fallbacks {
+		var bodies []xml.TokenReader
+		for _, b := range f.Body {
+			bodies = append(bodies, xmlstream.Wrap(nil, xml.StartElement{
+				Name: xml.Name{Local: "body"},
+				Attr: []xml.Attr{
+					{Name: xml.Name{Local: "start"}, Value: strconv.Itoa(b.Start)},
+					{Name: xml.Name{Local: "end"}, Value: strconv.Itoa(b.End)},
+				},
+			}))
+		}
+		inner = append(inner, xmlstream.Wrap(xmlstream.MultiReader(bodies...), xml.StartElement{
+			Name: xml.Name{Space: nsFallback, Local: "fallback"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "for"}, Value: f.For}},
+		}))
+	}
+	return xmlstream.MultiReader(inner...)
+}
@@ -0,0 +1,93 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"encoding/xml"
+	"strconv"
+	"testing"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+var retractTestCases = [...]struct {
+	in      string
+	id      string
+	from    string
+	to      string
+	sent    bool
+	private bool
+}{
+	0: {
+		in:   `<message xmlns="jabber:client" type="chat" from="juliet@example.net/balcony" to="hag66@example.net/pda" id="retract-1"><retract xmlns="urn:xmpp:message-retract:1" id="origin-1"/><body>fallback</body></message>`,
+		id:   "origin-1",
+		from: "juliet@example.net/balcony",
+		to:   "hag66@example.net/pda",
+	},
+	1: {
+		// Our own retraction reflected by the group chat.
+		in:   `<message xmlns="jabber:client" type="groupchat" from="coven@chat.example.net/firstwitch" to="hag66@example.net/pda" id="retract-2"><retract xmlns="urn:xmpp:message-retract:1" id="stanza-1"/><body>fallback</body></message>`,
+		id:   "stanza-1",
+		from: "hag66@example.net/pda",
+		to:   "coven@chat.example.net",
+		sent: true,
+	},
+	2: {
+		// Another occupant retracting their own message.
+		in:   `<message xmlns="jabber:client" type="groupchat" from="coven@chat.example.net/secondwitch" to="hag66@example.net/pda" id="retract-3"><retract xmlns="urn:xmpp:message-retract:1" id="stanza-2"/></message>`,
+		id:   "stanza-2",
+		from: "coven@chat.example.net/secondwitch",
+		to:   "hag66@example.net/pda",
+	},
+	3: {
+		// A private message from an occupant of a group chat.
+		in:      `<message xmlns="jabber:client" type="chat" from="coven@chat.example.net/secondwitch" to="hag66@example.net/pda" id="retract-4"><retract xmlns="urn:xmpp:message-retract:1" id="origin-2"/></message>`,
+		id:      "origin-2",
+		from:    "coven@chat.example.net/secondwitch",
+		to:      "hag66@example.net/pda",
+		private: true,
+	},
+}
+
+func TestRetract(t *testing.T) {
+	for i, tc := range retractTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var msgs []event.ChatMessage
+			c := newTestClient(t, func(v interface{}) {
+				if e, ok := v.(event.ChatMessage); ok {
+					msgs = append(msgs, e)
+				}
+			})
+			c.me.Store("coven@chat.example.net", jid.MustParse("coven@chat.example.net/firstwitch"))
+			name := xml.Name{Space: nsRetract, Local: "retract"}
+			handleStanza(t, tc.in,
+				mux.Message(stanza.ChatMessage, name, newRetractHandler(c)),
+				mux.Message(stanza.GroupChatMessage, name, newRetractHandler(c)),
+			)
+			if len(msgs) != 1 {
+				t.Fatalf("wrong number of messages: want=1, got=%d", len(msgs))
+			}
+			msg := msgs[0]
+			if msg.Retract.ID != tc.id {
+				t.Errorf("wrong retracted ID: want=%q, got=%q", tc.id, msg.Retract.ID)
+			}
+			if msg.Sent != tc.sent {
+				t.Errorf("wrong sent value: want=%t, got=%t", tc.sent, msg.Sent)
+			}
+			if (msg.Private != nil) != tc.private {
+				t.Errorf("wrong private value: want=%t, got=%t", tc.private, msg.Private != nil)
+			}
+			if from := jid.MustParse(tc.from); !msg.From.Equal(from) {
+				t.Errorf("wrong from: want=%s, got=%s", from, msg.From)
+			}
+			if to := jid.MustParse(tc.to); !msg.To.Equal(to) {
+				t.Errorf("wrong to: want=%s, got=%s", to, msg.To)
+			}
+		})
+	}
+}
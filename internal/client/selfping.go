@@ -0,0 +1,158 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/ping"
+	"mellium.im/xmpp/stanza"
+)
+
+// selfPingInterval is how often we check that we are still joined to each
+// group chat.
+const selfPingInterval = 5 * time.Minute
+
+// pingResult is the outcome of pinging ourselves in a group chat.
+type pingResult int
+
+const (
+	pingJoined pingResult = iota
+	pingUnknown
+	pingDropped
+)
+
+// selfPingResult interprets the result of pinging our own occupant JID as
+// described in MUC Self-Ping (XEP-0410).
+func selfPingResult(err error) pingResult {
+	var stanzaErr stanza.Error
+	switch {
+	case err == nil:
+		return pingJoined
+	case !errors.As(err, &stanzaErr):
+		// We timed out or the session is going away, so we can't tell whether we
+		// are still joined.
+		return pingUnknown
+	}
+	switch stanzaErr.Condition {
+	case stanza.ServiceUnavailable, stanza.FeatureNotImplemented:
+		// Our own client answered the ping but doesn't support it.
+		return pingJoined
+	case stanza.ItemNotFound:
+		// Our nickname was changed, possibly by one of our other clients.
+		return pingJoined
+	case stanza.RemoteServerNotFound, stanza.RemoteServerTimeout:
+		return pingUnknown
+	}
+	return pingDropped
+}
+
+// selfPing periodically pings ourselves in each joined group chat and rejoins
+// any that we have been dropped from (eg. because the group chat service was
+// restarted).
+// It runs until the context is canceled.
+func (c *Client) selfPing(ctx context.Context) {
+	ticker := time.NewTicker(selfPingInterval)
+	defer ticker.Stop()
+	for {
+		// Check immediately when we come online since a new session will not be
+		// joined to any of the group chats joined by the last one.
+		c.pingChannels(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Client) pingChannels(ctx context.Context) {
+	c.chanM.Lock()
+	rooms := make([]jid.JID, 0, len(c.channels))
+	for _, mucChan := range c.channels {
+		rooms = append(rooms, mucChan.Addr())
+	}
+	c.chanM.Unlock()
+
+	p := c.Printer()
+	for _, room := range rooms {
+		if ctx.Err() != nil {
+			return
+		}
+		me, ok := c.me.Load(room.String())
+		if !ok {
+			// We left or were removed from the group chat.
+			continue
+		}
+		sent := time.Now()
+		pingCtx, cancel := context.WithTimeout(ctx, c.timeout)
+		err := ping.Send(pingCtx, c.Session, me.(jid.JID))
+		cancel()
+		switch selfPingResult(err) {
+		case pingJoined:
+			continue
+		case pingUnknown:
+			c.debug.Print(p.Sprintf("could not tell if still joined to %s: %v", room, err))
+			continue
+		}
+
+		if c.joinedSince(room, sent) {
+			// The group chat was joined again while we were waiting for the ping
+			// (eg. it was opened again after we reconnected), so the error is from
+			// before we rejoined.
+			continue
+		}
+
+		c.debug.Print(p.Sprintf("dropped from %s, rejoining: %v", room, err))
+		c.handler(event.ChannelDisconnected(room))
+		joinCtx, cancel := context.WithTimeout(ctx, c.timeout)
+		err = c.rejoinMUC(joinCtx, room, sent)
+		cancel()
+		if err != nil {
+			c.logger.Print(p.Sprintf("error rejoining %s: %v", room, err))
+			continue
+		}
+		c.handler(event.ChannelRejoined(room))
+	}
+}
+
+// joinedSince reports whether the group chat room was joined after t.
+func (c *Client) joinedSince(room jid.JID, t time.Time) bool {
+	c.chanM.Lock()
+	defer c.chanM.Unlock()
+	return c.joinedAt[room.Bare().String()].After(t)
+}
+
+// rejoinMUC joins a group chat that we have been dropped from again using the
+// current session and the options it was first joined with.
+// If it has been joined since the given time it is not joined again.
+func (c *Client) rejoinMUC(ctx context.Context, room jid.JID, since time.Time) error {
+	s := room.Bare().String()
+	c.chanM.Lock()
+	defer c.chanM.Unlock()
+	_, ok := c.channels[s]
+	me, joined := c.me.Load(s)
+	if !ok || !joined {
+		// We left while we were pinging it.
+		return errNotJoined
+	}
+	if c.joinedAt[s].After(since) {
+		return nil
+	}
+	// The old channel may belong to a session that no longer exists, so join
+	// with a new one.
+	mucChan, err := c.joinFallback(ctx, me.(jid.JID), c.joinOpts[s]...)
+	if err != nil {
+		return err
+	}
+	c.channels[s] = mucChan
+	c.joinedAt[s] = time.Now()
+	c.me.Store(s, mucChan.Me())
+	return nil
+}
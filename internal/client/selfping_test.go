@@ -0,0 +1,63 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+	"mellium.im/xmpp/stanza"
+)
+
+var selfPingTestCases = [...]struct {
+	err      error
+	expected pingResult
+}{
+	0: {expected: pingJoined},
+	1: {err: stanza.Error{Condition: stanza.FeatureNotImplemented}, expected: pingJoined},
+	2: {err: stanza.Error{Condition: stanza.ItemNotFound}, expected: pingJoined},
+	3: {err: stanza.Error{Condition: stanza.RemoteServerTimeout}, expected: pingUnknown},
+	4: {err: stanza.Error{Condition: stanza.RemoteServerNotFound}, expected: pingUnknown},
+	5: {err: context.DeadlineExceeded, expected: pingUnknown},
+	6: {err: stanza.Error{Condition: stanza.NotAcceptable}, expected: pingDropped},
+	7: {err: stanza.Error{Condition: stanza.BadRequest}, expected: pingDropped},
+}
+
+func TestSelfPingResult(t *testing.T) {
+	for i, tc := range selfPingTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			result := selfPingResult(tc.err)
+			if result != tc.expected {
+				t.Errorf("wrong result for %v: want=%d, got=%d", tc.err, tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestRejoinJoinedSince(t *testing.T) {
+	room := jid.MustParse("coven@chat.example.net")
+	joined := time.Now()
+	c := &Client{
+		channels: map[string]*muc.Channel{room.String(): {}},
+		joinedAt: map[string]time.Time{room.String(): joined},
+	}
+	c.me.Store(room.String(), jid.MustParse("coven@chat.example.net/firstwitch"))
+	if !c.joinedSince(room, joined.Add(-time.Second)) {
+		t.Errorf("expected the group chat to be joined since the ping was sent")
+	}
+	// If the group chat was joined after the ping was sent it must not be joined
+	// again (which would fail here since there is no session).
+	err := c.rejoinMUC(context.Background(), room, joined.Add(-time.Second))
+	if err != nil {
+		t.Errorf("unexpected error rejoining: %v", err)
+	}
+	if !c.joinedAt[room.String()].Equal(joined) {
+		t.Errorf("group chat was joined again")
+	}
+}
@@ -0,0 +1,437 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"sync"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+)
+
+// nsSM is the namespace used by Stream Management (XEP-0198).
+const nsSM = "urn:xmpp:sm:3"
+
+// queuedMsg is a message that we have sent (or tried to send) but that the
+// server has not yet acknowledged.
+type queuedMsg struct {
+	// seq is the value of the outbound stanza counter after the message was
+	// written to the stream.
+	// It is only meaningful if sent is true.
+	seq  uint32
+	sent bool
+	msg  event.ChatMessage
+}
+
+// streamManagement tracks the state of a stream management session across
+// connections so that the session can be resumed and any messages that were in
+// flight when the connection was lost can be retransmitted.
+//
+// It is also an io.Writer that should be teed off of the output stream so that
+// it can count the stanzas that we send.
+type streamManagement struct {
+	m         sync.Mutex
+	supported bool
+	requested bool
+	enabled   bool
+	resumed   bool
+	resume    bool
+	id        string
+	inbound   uint32
+	outbound  uint32
+	unacked   []queuedMsg
+
+	// addr is the full address that was bound for the session so that it can be
+	// restored when the session is resumed.
+	addr jid.JID
+
+	// State used by the outbound stanza counter.
+	depth   int
+	inTag   bool
+	first   bool
+	endTag  bool
+	decl    bool
+	nameEnd bool
+	name    []byte
+	prev    byte
+}
+
+type smAnswer struct {
+	XMLName xml.Name `xml:"urn:xmpp:sm:3 a"`
+	H       uint32   `xml:"h,attr"`
+}
+
+func getAttr(start *xml.StartElement, local string) string {
+	for _, attr := range start.Attr {
+		if attr.Name.Local == local {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func isStanza(local string) bool {
+	switch local {
+	case "message", "presence", "iq":
+		return true
+	}
+	return false
+}
+
+// reset clears any state that only applies to a single stream.
+// It should be called before a new connection is established.
+// If the session is resumed on the new stream, tryResume enables stream
+// management again.
+func (sm *streamManagement) reset() {
+	sm.m.Lock()
+	defer sm.m.Unlock()
+	sm.supported = false
+	sm.requested = false
+	sm.enabled = false
+	sm.resumed = false
+	sm.depth = 0
+	sm.inTag = false
+}
+
+// forget ends the stream management session so that it will not be resumed.
+// Any messages that were never acknowledged are kept so that they can be sent
+// again on the next session.
+func (sm *streamManagement) forget() {
+	sm.m.Lock()
+	defer sm.m.Unlock()
+	sm.forgetLocked()
+}
+
+func (sm *streamManagement) forgetLocked() {
+	sm.requested = false
+	sm.enabled = false
+	sm.resume = false
+	sm.id = ""
+	sm.inbound = 0
+	sm.outbound = 0
+	for i := range sm.unacked {
+		sm.unacked[i].sent = false
+	}
+}
+
+// canResume reports whether the previous session may be resumed on the
+// current stream.
+func (sm *streamManagement) canResume() bool {
+	sm.m.Lock()
+	defer sm.m.Unlock()
+	return sm.supported && sm.resume && sm.id != ""
+}
+
+// isResumed reports whether the current session was resumed.
+func (sm *streamManagement) isResumed() bool {
+	sm.m.Lock()
+	defer sm.m.Unlock()
+	return sm.resumed
+}
+
+// Write implements io.Writer and counts the number of top level stanzas that
+// are written to the output stream.
+func (sm *streamManagement) Write(p []byte) (int, error) {
+	sm.m.Lock()
+	defer sm.m.Unlock()
+	for _, b := range p {
+		sm.scan(b)
+	}
+	return len(p), nil
+}
+
+// scan is a minimal tokenizer that tracks the element depth of the output
+// stream.
+// We never write comments, CDATA sections, or unescaped angle brackets so it
+// does not need to be a full XML parser.
+func (sm *streamManagement) scan(b byte) {
+	prev := sm.prev
+	sm.prev = b
+	if !sm.inTag {
+		if b == '<' {
+			sm.inTag = true
+			sm.first = true
+			sm.endTag = false
+			sm.decl = false
+			sm.nameEnd = false
+			sm.name = sm.name[:0]
+		}
+		return
+	}
+	if sm.first {
+		sm.first = false
+		switch b {
+		case '/':
+			sm.endTag = true
+			return
+		case '?':
+			sm.decl = true
+			return
+		}
+	}
+	if b != '>' {
+		if !sm.nameEnd {
+			switch b {
+			case ' ', '\t', '\r', '\n', '/':
+				sm.nameEnd = true
+			default:
+				sm.name = append(sm.name, b)
+			}
+		}
+		return
+	}
+
+	sm.inTag = false
+	switch {
+	case sm.decl:
+	case sm.endTag:
+		sm.depth--
+	case string(sm.name) == "stream:stream":
+		// Stream restarts open a new stream without closing the old one.
+		sm.depth = 1
+	default:
+		if sm.depth == 1 && sm.requested && isStanza(string(sm.name)) {
+			sm.outbound++
+		}
+		if prev != '/' {
+			sm.depth++
+		}
+	}
+}
+
+// queue records a message so that it can be retransmitted if the server never
+// acknowledges it.
+func (sm *streamManagement) queue(msg event.ChatMessage, sent bool) {
+	sm.m.Lock()
+	defer sm.m.Unlock()
+	// If stream management was never enabled nothing will ever acknowledge the
+	// message, so don't hold on to it forever.
+	if sent && !sm.requested {
+		return
+	}
+	sm.unacked = append(sm.unacked, queuedMsg{
+		seq:  sm.outbound,
+		sent: sent,
+		msg:  msg,
+	})
+}
+
+// ack drops any queued messages that the server has acknowledged.
+func (sm *streamManagement) ack(h uint32) {
+	sm.m.Lock()
+	defer sm.m.Unlock()
+	filtered := sm.unacked[:0]
+	for _, q := range sm.unacked {
+		// Compare using signed arithmetic so that this still works if the counter
+		// wraps.
+		if q.sent && int32(h-q.seq) >= 0 { // #nosec G115
+			continue
+		}
+		filtered = append(filtered, q)
+	}
+	sm.unacked = filtered
+}
+
+// pending removes and returns all messages that have not been acknowledged.
+func (sm *streamManagement) pending() []event.ChatMessage {
+	sm.m.Lock()
+	defer sm.m.Unlock()
+	msgs := make([]event.ChatMessage, 0, len(sm.unacked))
+	for _, q := range sm.unacked {
+		msgs = append(msgs, q.msg)
+	}
+	sm.unacked = nil
+	return msgs
+}
+
+// feature returns a stream feature that records whether stream management was
+// advertised by the server.
+// Stream management is not actually negotiated until after resource binding,
+// see enable.
+func (sm *streamManagement) feature() xmpp.StreamFeature {
+	return xmpp.StreamFeature{
+		Name:       xml.Name{Space: nsSM, Local: "sm"},
+		Necessary:  xmpp.Authn,
+		Prohibited: xmpp.Ready,
+		Parse: func(ctx context.Context, d *xml.Decoder, start *xml.StartElement) (bool, interface{}, error) {
+			sm.m.Lock()
+			sm.supported = true
+			sm.m.Unlock()
+			return false, nil, d.Skip()
+		},
+	}
+}
+
+// bindOrResume returns a resource binding feature that attempts to resume the
+// previous stream management session before falling back to binding a new
+// resource.
+func (sm *streamManagement) bindOrResume() xmpp.StreamFeature {
+	bind := xmpp.BindResource()
+	negotiateBind := bind.Negotiate
+	bind.Negotiate = func(ctx context.Context, session *xmpp.Session, data interface{}) (xmpp.SessionState, io.ReadWriter, error) {
+		if sm.canResume() {
+			resumed, err := sm.tryResume(session)
+			if err != nil {
+				return 0, nil, err
+			}
+			if resumed {
+				return xmpp.Ready, nil, nil
+			}
+		}
+		state, rw, err := negotiateBind(ctx, session, data)
+		if err == nil {
+			sm.m.Lock()
+			sm.addr = session.LocalAddr()
+			sm.m.Unlock()
+		}
+		return state, rw, err
+	}
+	return bind
+}
+
+func (sm *streamManagement) tryResume(session *xmpp.Session) (bool, error) {
+	sm.m.Lock()
+	id, h := sm.id, sm.inbound
+	sm.m.Unlock()
+
+	w := session.TokenWriter()
+	defer w.Close()
+	r := session.TokenReader()
+	defer r.Close()
+
+	_, err := xmlstream.Copy(w, xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: nsSM, Local: "resume"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "previd"}, Value: id},
+			{Name: xml.Name{Local: "h"}, Value: strconv.FormatUint(uint64(h), 10)},
+		},
+	}))
+	if err != nil {
+		return false, err
+	}
+	if err = w.Flush(); err != nil {
+		return false, err
+	}
+
+	d := xml.NewTokenDecoder(r)
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return false, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if err = d.Skip(); err != nil {
+			return false, err
+		}
+		if h, err := strconv.ParseUint(getAttr(&start, "h"), 10, 32); err == nil {
+			sm.ack(uint32(h))
+		}
+		if start.Name.Space == nsSM && start.Name.Local == "resumed" {
+			sm.m.Lock()
+			sm.resumed = true
+			sm.requested = true
+			sm.enabled = true
+			addr := sm.addr
+			sm.m.Unlock()
+			// Resuming skips resource binding, so the session still has the bare
+			// address that we authenticated as.
+			session.UpdateAddr(addr)
+			return true, nil
+		}
+		// Anything else (normally <failed/>) means that the old session is gone
+		// and we need to bind a new resource.
+		sm.forget()
+		return false, nil
+	}
+}
+
+// enable requests that stream management be enabled on the current session if
+// the server supports it and we did not resume a previous session.
+func (sm *streamManagement) enable(ctx context.Context, session *xmpp.Session) error {
+	sm.m.Lock()
+	if !sm.supported || sm.resumed {
+		sm.m.Unlock()
+		return nil
+	}
+	sm.forgetLocked()
+	sm.requested = true
+	sm.m.Unlock()
+
+	return session.Send(ctx, xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: nsSM, Local: "enable"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "resume"}, Value: "true"}},
+	}))
+}
+
+// requestAck asks the server to tell us how many stanzas it has received.
+func (sm *streamManagement) requestAck(ctx context.Context, session *xmpp.Session) error {
+	sm.m.Lock()
+	enabled := sm.enabled
+	sm.m.Unlock()
+	if !enabled {
+		return nil
+	}
+	return session.Send(ctx, xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: nsSM, Local: "r"},
+	}))
+}
+
+// handler wraps h to count incoming stanzas and respond to stream management
+// requests.
+func (sm *streamManagement) handler(c *Client, h xmpp.Handler) xmpp.Handler {
+	return xmpp.HandlerFunc(func(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		if start.Name.Space == nsSM {
+			return sm.handleNonza(c, t, start)
+		}
+		if isStanza(start.Name.Local) {
+			sm.m.Lock()
+			if sm.enabled {
+				sm.inbound++
+			}
+			sm.m.Unlock()
+		}
+		return h.HandleXMPP(t, start)
+	})
+}
+
+func (sm *streamManagement) handleNonza(c *Client, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	p := c.Printer()
+	switch start.Name.Local {
+	case "enabled":
+		resume := getAttr(start, "resume")
+		sm.m.Lock()
+		sm.enabled = true
+		sm.inbound = 0
+		sm.id = getAttr(start, "id")
+		sm.resume = resume == "true" || resume == "1"
+		sm.m.Unlock()
+		c.debug.Print(p.Sprintf("stream management enabled (resumable: %t)", resume == "true" || resume == "1"))
+	case "failed":
+		sm.forget()
+		c.debug.Print(p.Sprintf("enabling stream management failed"))
+	case "r":
+		sm.m.Lock()
+		h := sm.inbound
+		sm.m.Unlock()
+		return t.Encode(smAnswer{H: h})
+	case "a":
+		h, err := strconv.ParseUint(getAttr(start, "h"), 10, 32)
+		if err != nil {
+			c.debug.Print(p.Sprintf("invalid stream management acknowledgement: %v", err))
+			return nil
+		}
+		sm.ack(uint32(h))
+	}
+	return nil
+}
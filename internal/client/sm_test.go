@@ -0,0 +1,118 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"mellium.im/communique/internal/client/event"
+)
+
+var countTestCases = [...]struct {
+	out      []string
+	expected uint32
+}{
+	0: {},
+	1: {
+		out:      []string{`<?xml version="1.0"?><stream:stream to="example.net"><iq type="get" id="1"><ping xmlns="urn:xmpp:ping"></ping></iq>`},
+		expected: 1,
+	},
+	2: {
+		out: []string{
+			`<stream:stream to="example.net">`,
+			`<message to="a@example.net"><body>a &lt;b&gt; c</body></message>`,
+			`<presence/><r xmlns="urn:xmpp:sm:3"/>`,
+			`<a xmlns="urn:xmpp:sm:3" h="3"></a>`,
+		},
+		expected: 2,
+	},
+	3: {
+		// Writes may be split at arbitrary points.
+		out:      []string{`<stream:stream><mess`, `age><bo`, `dy>hi</body></message`, `><iq id="1"/`, `>`},
+		expected: 2,
+	},
+	4: {
+		// Stream restarts do not close the old stream.
+		out:      []string{`<stream:stream><starttls/>`, `<stream:stream><message></message>`},
+		expected: 1,
+	},
+	5: {
+		// Nested stanzas (for example, forwarded messages) are not counted.
+		out:      []string{`<stream:stream><message><forwarded><message><body/></message></forwarded></message>`},
+		expected: 1,
+	},
+}
+
+func TestCountStanzas(t *testing.T) {
+	for i, tc := range countTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			sm := &streamManagement{requested: true}
+			for _, s := range tc.out {
+				_, err := sm.Write([]byte(s))
+				if err != nil {
+					t.Fatalf("unexpected error writing: %v", err)
+				}
+			}
+			if sm.outbound != tc.expected {
+				t.Errorf("wrong number of stanzas counted: want=%d, got=%d", tc.expected, sm.outbound)
+			}
+		})
+	}
+}
+
+func TestAck(t *testing.T) {
+	sm := &streamManagement{requested: true}
+	_, err := sm.Write([]byte(`<stream:stream>`))
+	if err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		_, err = sm.Write([]byte(`<message></message>`))
+		if err != nil {
+			t.Fatalf("unexpected error writing: %v", err)
+		}
+		sm.queue(event.ChatMessage{Body: strconv.Itoa(i)}, true)
+	}
+	sm.queue(event.ChatMessage{Body: "unsent"}, false)
+	sm.ack(2)
+	if l := len(sm.unacked); l != 2 {
+		t.Fatalf("wrong number of unacked messages: want=2, got=%d", l)
+	}
+	if q := sm.unacked[0]; q.seq != 3 || !q.sent || q.msg.Body != "2" {
+		t.Errorf("wrong message left in queue: %+v", q)
+	}
+	if q := sm.unacked[1]; q.sent || q.msg.Body != "unsent" {
+		t.Errorf("message that was never sent should not be acked: %+v", q)
+	}
+}
+
+func TestResetWithoutSM(t *testing.T) {
+	sm := &streamManagement{
+		supported: true,
+		requested: true,
+		enabled:   true,
+		resume:    true,
+		id:        "1",
+	}
+	// The next stream is to a server that does not advertise stream management,
+	// so the feature is never parsed.
+	sm.reset()
+	if sm.requested || sm.enabled {
+		t.Errorf("stream management still enabled after reset: requested=%t, enabled=%t", sm.requested, sm.enabled)
+	}
+	if sm.canResume() {
+		t.Errorf("expected not to resume on a stream without stream management")
+	}
+	// A nil session is never used if stream management is not enabled.
+	if err := sm.requestAck(context.Background(), nil); err != nil {
+		t.Errorf("unexpected error requesting ack: %v", err)
+	}
+	sm.queue(event.ChatMessage{Body: "sent"}, true)
+	if l := len(sm.unacked); l != 0 {
+		t.Errorf("sent message queued without stream management: %d queued", l)
+	}
+}
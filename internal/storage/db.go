@@ -32,36 +32,81 @@ import (
 	"mellium.im/xmpp/stanza"
 )
 
+// selectHistory is the start of the queries used to load pages of the history
+// of a conversation.
+const selectHistory = `
+SELECT m.sent, m.toAttr, m.fromAttr, m.idAttr, m.body, m.stanzaType, m.delay, m.retracted, m.archiveID, m.displayed, m.received, m.failed,
+		(SELECT group_concat(reaction, char(31) ORDER BY id)
+			FROM reactions
+			WHERE reactions.message=m.id),
+		m.replyID, m.replyStart, m.replyEnd,
+		q.sent, q.toAttr, q.fromAttr, q.idAttr, q.body, q.retracted,
+		m.id AS rowID
+	FROM messages AS m
+		LEFT JOIN messages AS q ON q.id=(
+			SELECT r.id
+				FROM messages AS r
+				WHERE r.rosterJID=m.rosterJID
+					AND (CASE m.stanzaType WHEN 'groupchat' THEN r.archiveID=m.replyID ELSE (r.idAttr=m.replyID OR r.originID=m.replyID) END)
+				ORDER BY r.id DESC
+				LIMIT 1)
+	WHERE m.rosterJID=$1
+		AND m.stanzaType=COALESCE(NULLIF($2, ''), m.stanzaType)`
+
 // DB represents a SQL database with common pre-prepared statements.
 type DB struct {
 	*sql.DB
-	txM               sync.Mutex
-	truncateRoster    *sql.Stmt
-	delRoster         *sql.Stmt
-	insertRoster      *sql.Stmt
-	insertGroup       *sql.Stmt
-	insertRosterVer   *sql.Stmt
-	selectRosterVer   *sql.Stmt
-	selectRoster      *sql.Stmt
-	insertMsg         *sql.Stmt
-	markRecvd         *sql.Stmt
-	queryMsg          *sql.Stmt
-	afterID           *sql.Stmt
-	beforeID          *sql.Stmt
-	insertCaps        *sql.Stmt
-	getCaps           *sql.Stmt
-	getIdent          *sql.Stmt
-	getFeature        *sql.Stmt
-	getForms          *sql.Stmt
-	getServices       *sql.Stmt
-	insertJIDCaps     *sql.Stmt
-	insertJIDCapsForm *sql.Stmt
-	insertIdent       *sql.Stmt
-	insertIdentJID    *sql.Stmt
-	insertFeature     *sql.Stmt
-	insertFeatureJID  *sql.Stmt
-	p                 *message.Printer
-	debug             *log.Logger
+	txM                     sync.Mutex
+	truncateRoster          *sql.Stmt
+	delRoster               *sql.Stmt
+	insertRoster            *sql.Stmt
+	insertGroup             *sql.Stmt
+	insertRosterVer         *sql.Stmt
+	selectRosterVer         *sql.Stmt
+	selectRoster            *sql.Stmt
+	upsertConv              *sql.Stmt
+	deleteConv              *sql.Stmt
+	readConv                *sql.Stmt
+	selectConvs             *sql.Stmt
+	insertMsg               *sql.Stmt
+	markRecvd               *sql.Stmt
+	markFailed              *sql.Stmt
+	selectCorrected         *sql.Stmt
+	selectCorrectedOccupant *sql.Stmt
+	insertEdit              *sql.Stmt
+	updateBody              *sql.Stmt
+	retractMsg              *sql.Stmt
+	moderateMsg             *sql.Stmt
+	retractSentMsg          *sql.Stmt
+	deleteEdits             *sql.Stmt
+	selectReacted           *sql.Stmt
+	selectModerated         *sql.Stmt
+	selectReactions         *sql.Stmt
+	deleteReactions         *sql.Stmt
+	insertReaction          *sql.Stmt
+	selectQuoted            *sql.Stmt
+	markDisplayed           *sql.Stmt
+	lastMarkable            *sql.Stmt
+	queryMsg                *sql.Stmt
+	queryMsgAfter           *sql.Stmt
+	searchMsg               *sql.Stmt
+	afterID                 *sql.Stmt
+	lastID                  *sql.Stmt
+	beforeID                *sql.Stmt
+	insertCaps              *sql.Stmt
+	getCaps                 *sql.Stmt
+	getIdent                *sql.Stmt
+	getFeature              *sql.Stmt
+	getForms                *sql.Stmt
+	getServices             *sql.Stmt
+	insertJIDCaps           *sql.Stmt
+	insertJIDCapsForm       *sql.Stmt
+	insertIdent             *sql.Stmt
+	insertIdentJID          *sql.Stmt
+	insertFeature           *sql.Stmt
+	insertFeatureJID        *sql.Stmt
+	p                       *message.Printer
+	debug                   *log.Logger
 }
 
 // OpenDB attempts to open the database at dbFile.
@@ -200,10 +245,48 @@ SELECT jid,name,subs FROM rosterJIDs`)
 		return nil, err
 	}
 
+	wrapDB.upsertConv, err = db.PrepareContext(ctx, `
+INSERT INTO conversations (jid, name, room, notify, mutedUntil, position)
+	VALUES ($1, $2, $3, $4, $5, (SELECT IFNULL(MAX(position), 0)+1 FROM conversations))
+	ON CONFLICT (jid) DO UPDATE SET name=$2, room=$3, notify=$4, mutedUntil=$5`)
+	if err != nil {
+		return nil, err
+	}
+	wrapDB.deleteConv, err = db.PrepareContext(ctx, `
+DELETE FROM conversations WHERE jid=$1`)
+	if err != nil {
+		return nil, err
+	}
+	wrapDB.readConv, err = db.PrepareContext(ctx, `
+UPDATE conversations SET lastRead=(
+		SELECT id FROM messages
+			WHERE rosterJID=$1
+			ORDER BY delay DESC, id DESC
+			LIMIT 1)
+	WHERE jid=$1`)
+	if err != nil {
+		return nil, err
+	}
+	wrapDB.selectConvs, err = db.PrepareContext(ctx, `
+SELECT c.jid, c.name, c.room, c.notify, c.mutedUntil, u.id IS NOT NULL, IFNULL(u.idAttr, '')
+	FROM conversations AS c
+		LEFT JOIN messages AS u ON u.id=(
+			SELECT m.id
+				FROM messages AS m
+				WHERE m.rosterJID=c.jid
+					AND m.sent=FALSE
+					AND m.delay>IFNULL((SELECT delay FROM messages WHERE id=c.lastRead), -1)
+				ORDER BY m.delay ASC, m.id ASC
+				LIMIT 1)
+	ORDER BY c.position ASC`)
+	if err != nil {
+		return nil, err
+	}
+
 	wrapDB.insertMsg, err = db.PrepareContext(ctx, `
 INSERT INTO messages
-	(sent, toAttr, fromAttr, idAttr, body, stanzaType, originID, delay, rosterJID, archiveID)
-	VALUES ($1, $2, $3, $4, $5, $6, $7, IFNULL(NULLIF($8, 0), CAST(strftime('%s', 'now') AS INTEGER)), $9, $10)
+	(sent, toAttr, fromAttr, idAttr, body, stanzaType, originID, delay, rosterJID, archiveID, replyID, replyStart, replyEnd, markable, occupant, realJID)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, IFNULL(NULLIF($8, 0), CAST(strftime('%s', 'now') AS INTEGER)), $9, $10, $11, $12, $13, $14, $15, $16)
 	ON CONFLICT (originID, fromAttr) DO UPDATE SET archiveID=$10
 	ON CONFLICT (archiveID) DO NOTHING
 	RETURNING id`)
@@ -216,33 +299,199 @@ UPDATE messages SET received=TRUE WHERE sent=TRUE AND (idAttr=$1 OR originID=$1)
 	if err != nil {
 		return nil, err
 	}
+	wrapDB.markFailed, err = db.PrepareContext(ctx, `
+UPDATE messages SET failed=TRUE WHERE sent=TRUE AND (idAttr=$1 OR originID=$1)`)
+	if err != nil {
+		return nil, err
+	}
 
-	wrapDB.queryMsg, err = db.PrepareContext(ctx, `
-SELECT sent, toAttr, fromAttr, idAttr, body, stanzaType
+	wrapDB.selectCorrected, err = db.PrepareContext(ctx, `
+SELECT id, body
+	FROM messages
+	WHERE fromAttr=$1 AND rosterJID=$2 AND (idAttr=$3 OR originID=$3)
+	ORDER BY id DESC
+	LIMIT 1`)
+	if err != nil {
+		return nil, err
+	}
+	// If we know the real JIDs of both the sender of the correction and the
+	// original message compare those, otherwise fall back to the occupant JID.
+	wrapDB.selectCorrectedOccupant, err = db.PrepareContext(ctx, `
+SELECT id, body
+	FROM messages
+	WHERE rosterJID=$1 AND sent=FALSE AND (idAttr=$2 OR originID=$2)
+		AND CASE WHEN realJID IS NOT NULL AND $4<>'' THEN realJID=$4 ELSE occupant=$3 END
+	ORDER BY id DESC
+	LIMIT 1`)
+	if err != nil {
+		return nil, err
+	}
+	wrapDB.insertEdit, err = db.PrepareContext(ctx, `
+INSERT INTO messageEdits (message, body)
+	VALUES ($1, $2)`)
+	if err != nil {
+		return nil, err
+	}
+	wrapDB.updateBody, err = db.PrepareContext(ctx, `
+UPDATE messages SET body=$2, replyStart=$3, replyEnd=$4 WHERE id=$1`)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapDB.retractMsg, err = db.PrepareContext(ctx, `
+UPDATE messages SET body='', retracted=TRUE
+	WHERE fromAttr=$1 AND rosterJID=$2 AND (idAttr=$3 OR originID=$3)
+	RETURNING id`)
+	if err != nil {
+		return nil, err
+	}
+	wrapDB.moderateMsg, err = db.PrepareContext(ctx, `
+UPDATE messages SET body='', retracted=TRUE
+	WHERE rosterJID=$1 AND archiveID=$2
+	RETURNING id`)
+	if err != nil {
+		return nil, err
+	}
+	wrapDB.retractSentMsg, err = db.PrepareContext(ctx, `
+UPDATE messages SET body='', retracted=TRUE
+	WHERE rosterJID=$1 AND archiveID=$2 AND sent=TRUE
+	RETURNING id`)
+	if err != nil {
+		return nil, err
+	}
+	wrapDB.deleteEdits, err = db.PrepareContext(ctx, `
+DELETE FROM messageEdits WHERE message=$1`)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapDB.selectReacted, err = db.PrepareContext(ctx, `
+SELECT id
+	FROM messages
+	WHERE rosterJID=$1 AND (idAttr=$2 OR originID=$2)
+	ORDER BY id DESC
+	LIMIT 1`)
+	if err != nil {
+		return nil, err
+	}
+	wrapDB.selectModerated, err = db.PrepareContext(ctx, `
+SELECT id
+	FROM messages
+	WHERE rosterJID=$1 AND archiveID=$2`)
+	if err != nil {
+		return nil, err
+	}
+	wrapDB.selectReactions, err = db.PrepareContext(ctx, `
+SELECT reaction
+	FROM reactions
+	WHERE message=$1 AND sender=$2
+	ORDER BY id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	wrapDB.deleteReactions, err = db.PrepareContext(ctx, `
+DELETE FROM reactions WHERE message=$1 AND sender=$2`)
+	if err != nil {
+		return nil, err
+	}
+	wrapDB.insertReaction, err = db.PrepareContext(ctx, `
+INSERT INTO reactions (message, sender, reaction)
+	VALUES ($1, $2, $3)
+	ON CONFLICT DO NOTHING`)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapDB.selectQuoted, err = db.PrepareContext(ctx, `
+SELECT sent, toAttr, fromAttr, idAttr, body, retracted
 	FROM messages
 	WHERE rosterJID=$1
-		AND stanzaType=COALESCE(NULLIF($2, ''), stanzaType)
-	ORDER BY delay ASC`)
+		AND (CASE $3 WHEN 'groupchat' THEN archiveID=$2 ELSE (idAttr=$2 OR originID=$2) END)
+	ORDER BY id DESC
+	LIMIT 1`)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapDB.markDisplayed, err = db.PrepareContext(ctx, `
+UPDATE messages SET displayed=TRUE
+	WHERE rosterJID=$1 AND sent=$2 AND delay<=(SELECT delay FROM messages WHERE id=$3)`)
+	if err != nil {
+		return nil, err
+	}
+	wrapDB.lastMarkable, err = db.PrepareContext(ctx, `
+SELECT idAttr, archiveID, stanzaType, displayed
+	FROM messages
+	WHERE rosterJID=$1 AND sent=FALSE AND markable=TRUE
+	ORDER BY delay DESC, id DESC
+	LIMIT 1`)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapDB.queryMsg, err = db.PrepareContext(ctx, `
+SELECT * FROM (`+selectHistory+`
+		AND ($3=0 OR (m.delay, m.id)<(SELECT delay, id FROM messages WHERE id=$3))
+	ORDER BY m.delay DESC, m.id DESC
+	LIMIT $4)
+	ORDER BY delay ASC, rowID ASC`)
+	if err != nil {
+		return nil, err
+	}
+	wrapDB.queryMsgAfter, err = db.PrepareContext(ctx, selectHistory+`
+		AND (m.delay, m.id)>(SELECT delay, id FROM messages WHERE id=$3)
+	ORDER BY m.delay ASC, m.id ASC
+	LIMIT $4`)
 	if err != nil {
 		return nil, err
 	}
+	wrapDB.searchMsg, err = db.PrepareContext(ctx, `
+SELECT m.rosterJID, m.sent, m.toAttr, m.fromAttr, m.idAttr, m.archiveID, m.stanzaType, m.delay,
+		snippet(messagesFTS, 0, char(2), char(3), '…', 12)
+	FROM messagesFTS AS f
+		INNER JOIN messages AS m ON m.id=f.rowid
+	WHERE messagesFTS MATCH $1
+		AND m.rosterJID=COALESCE(NULLIF($2, ''), m.rosterJID)
+		AND m.retracted=FALSE
+	ORDER BY m.delay DESC, m.id DESC
+	LIMIT $3`)
+	if err != nil {
+		return nil, err
+	}
+	// Not every message has an archive ID (eg. messages we sent that the server
+	// hasn't returned from the archive yet), so the ID is the last or first one
+	// that does while the time is the last or first of any message.
 	wrapDB.afterID, err = db.PrepareContext(ctx, `
-SELECT j.jid, m.archiveID, MAX(m.delay)
+SELECT j.jid,
+	(SELECT a.archiveID FROM messages AS a
+		WHERE a.rosterJID=j.jid AND a.archiveID IS NOT NULL
+		ORDER BY a.delay DESC, a.id DESC LIMIT 1),
+	MAX(m.delay)
 	FROM messages AS m
 		INNER JOIN rosterJIDs AS j ON m.rosterJID=j.jid
 	GROUP BY j.jid`)
 	if err != nil {
 		return nil, err
 	}
+	wrapDB.lastID, err = db.PrepareContext(ctx, `
+SELECT
+	(SELECT a.archiveID FROM messages AS a
+		WHERE a.rosterJID=$1 AND a.archiveID IS NOT NULL
+		ORDER BY a.delay DESC, a.id DESC LIMIT 1),
+	MAX(delay)
+	FROM messages
+	WHERE rosterJID=$1`)
 	if err != nil {
 		return nil, err
 	}
 	wrapDB.beforeID, err = db.PrepareContext(ctx, `
-SELECT archiveID, MIN(delay) AS mindelay
+SELECT
+	(SELECT a.archiveID FROM messages AS a
+		WHERE a.rosterJID=$1 AND a.archiveID IS NOT NULL
+		ORDER BY a.delay ASC, a.id ASC LIMIT 1),
+	MIN(delay)
 	FROM messages
-	WHERE rosterJID=$1
-	GROUP BY delay
-	HAVING mindelay NOT NULL`)
+	WHERE rosterJID=$1`)
 	if err != nil {
 		return nil, err
 	}
@@ -395,6 +644,15 @@ func (db *DB) MarkReceived(ctx context.Context, e event.Receipt) error {
 	})
 }
 
+// MarkFailed marks a message that we sent as failed after an error was
+// returned for it.
+func (db *DB) MarkFailed(ctx context.Context, id string) error {
+	return execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.Stmt(db.markFailed).ExecContext(ctx, id)
+		return err
+	})
+}
+
 // InsertMsg adds a message to the database.
 func (db *DB) InsertMsg(ctx context.Context, respectDelay bool, msg event.ChatMessage, addr jid.JID) error {
 	return execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
@@ -407,12 +665,7 @@ func (db *DB) InsertMsg(ctx context.Context, respectDelay bool, msg event.ChatMe
 		if msg.From.Equal(jid.JID{}) {
 			msg.From = addr
 		}
-		var rosterJID string
-		if msg.Sent {
-			rosterJID = msg.To.Bare().String()
-		} else {
-			rosterJID = msg.From.Bare().String()
-		}
+		rosterJID := msg.With().String()
 		var originID *string
 		switch {
 		case msg.OriginID.ID != "":
@@ -425,16 +678,124 @@ func (db *DB) InsertMsg(ctx context.Context, respectDelay bool, msg event.ChatMe
 			originID = &msg.ID
 		}
 
+		// Group chat messages are archived by the group chat itself, so that is the
+		// stanza ID that moderators will reference.
+		archiveBy := addr.Bare().String()
+		if msg.Type == stanza.GroupChatMessage {
+			archiveBy = rosterJID
+		}
 		var domainSID *string
 		for _, sid := range msg.SID {
-			if sid.By.String() == addr.Bare().String() {
+			if sid.By.String() == archiveBy {
 				domainSID = &sid.ID
 				break
 			}
 		}
 
+		var replyID *string
+		if msg.Reply.ID != "" {
+			replyID = &msg.Reply.ID
+		}
+		replyStart, replyEnd := replyFallback(msg)
+
+		// Group chat messages are stored as being from the group chat, so keep
+		// track of the occupant that sent them separately.
+		var occupant, realJID *string
+		if msg.Type == stanza.GroupChatMessage && !msg.Sent {
+			s := msg.From.String()
+			occupant = &s
+			if !msg.RealJID.Equal(jid.JID{}) {
+				s := msg.RealJID.Bare().String()
+				realJID = &s
+			}
+		}
+
+		var msgRID uint64
+		err := tx.Stmt(db.insertMsg).QueryRowContext(ctx, msg.Sent, msg.To.Bare().String(), msg.From.Bare().String(), msg.ID, msg.Body, msg.Type, originID, delay, rosterJID, domainSID, replyID, replyStart, replyEnd, msg.Markable != nil, occupant, realJID).Scan(&msgRID)
+		switch err {
+		case sql.ErrNoRows:
+			return nil
+		case nil:
+		default:
+			return err
+		}
+
+		return nil
+	})
+}
+
+// CorrectMsg replaces the body of an earlier message with the body of a
+// correction (XEP-0308), keeping the old body in the edit history.
+// Only messages sent by the same address as the correction can be corrected,
+// or in group chats by the same occupant (or the same real JID if the group
+// chat exposes it).
+// If no such message exists false is returned.
+func (db *DB) CorrectMsg(ctx context.Context, msg event.ChatMessage, addr jid.JID) (bool, error) {
+	var found bool
+	err := execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		if msg.From.Equal(jid.JID{}) {
+			msg.From = addr
+		}
+		rosterJID := msg.With().String()
+
+		var msgRID uint64
+		var body *string
+		var err error
+		if msg.Type == stanza.GroupChatMessage && !msg.Sent {
+			var realJID string
+			if !msg.RealJID.Equal(jid.JID{}) {
+				realJID = msg.RealJID.Bare().String()
+			}
+			err = tx.Stmt(db.selectCorrectedOccupant).QueryRowContext(ctx, rosterJID, msg.Replace.ID, msg.From.String(), realJID).Scan(&msgRID, &body)
+		} else {
+			err = tx.Stmt(db.selectCorrected).QueryRowContext(ctx, msg.From.Bare().String(), rosterJID, msg.Replace.ID).Scan(&msgRID, &body)
+		}
+		switch err {
+		case sql.ErrNoRows:
+			return nil
+		case nil:
+		default:
+			return err
+		}
+		found = true
+		_, err = tx.Stmt(db.insertEdit).ExecContext(ctx, msgRID, body)
+		if err != nil {
+			return err
+		}
+		// The fallback for a reply may have changed along with the body.
+		replyStart, replyEnd := replyFallback(msg)
+		_, err = tx.Stmt(db.updateBody).ExecContext(ctx, msgRID, msg.Body, replyStart, replyEnd)
+		return err
+	})
+	return found, err
+}
+
+// RetractMsg replaces a message with a tombstone after it was retracted
+// (XEP-0424) by the person that sent it, or by a group chat moderator
+// (XEP-0425).
+// Any earlier versions of the message are also removed.
+// Only messages sent by the same address as the retraction can be retracted,
+// unless the retraction came from the group chat itself or we sent it to a group
+// chat in which case the ID is the stanza ID assigned by the group chat.
+// If no such message exists false is returned.
+func (db *DB) RetractMsg(ctx context.Context, msg event.ChatMessage, addr jid.JID) (bool, error) {
+	var found bool
+	err := execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		if msg.From.Equal(jid.JID{}) {
+			msg.From = addr
+		}
+		rosterJID := msg.With().String()
+
 		var msgRID uint64
-		err := tx.Stmt(db.insertMsg).QueryRowContext(ctx, msg.Sent, msg.To.Bare().String(), msg.From.Bare().String(), msg.ID, msg.Body, msg.Type, originID, delay, rosterJID, domainSID).Scan(&msgRID)
+		var err error
+		switch {
+		case msg.Type == stanza.GroupChatMessage && msg.Sent:
+			err = tx.Stmt(db.retractSentMsg).QueryRowContext(ctx, rosterJID, msg.Retract.ID).Scan(&msgRID)
+		case msg.Type == stanza.GroupChatMessage && msg.From.Equal(msg.From.Bare()):
+			err = tx.Stmt(db.moderateMsg).QueryRowContext(ctx, rosterJID, msg.Retract.ID).Scan(&msgRID)
+		default:
+			err = tx.Stmt(db.retractMsg).QueryRowContext(ctx, msg.From.Bare().String(), rosterJID, msg.Retract.ID).Scan(&msgRID)
+		}
 		switch err {
 		case sql.ErrNoRows:
 			return nil
@@ -442,9 +803,163 @@ func (db *DB) InsertMsg(ctx context.Context, respectDelay bool, msg event.ChatMe
 		default:
 			return err
 		}
+		found = true
+		_, err = tx.Stmt(db.deleteEdits).ExecContext(ctx, msgRID)
+		return err
+	})
+	return found, err
+}
 
+// React replaces the reactions (XEP-0444) from the sender of msg to the message
+// that it references.
+// In one-to-one chats the message is referenced by its ID, and in group chats
+// it is referenced by the stanza ID assigned by the group chat.
+// If no such message exists false is returned.
+func (db *DB) React(ctx context.Context, msg event.ChatMessage, addr jid.JID) (bool, error) {
+	var found bool
+	err := execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		if msg.From.Equal(jid.JID{}) {
+			msg.From = addr
+		}
+		msgRID, err := db.referencedMsg(ctx, tx, msg, msg.Reactions.ID)
+		switch err {
+		case sql.ErrNoRows:
+			return nil
+		case nil:
+		default:
+			return err
+		}
+		found = true
+
+		sender := reactionSender(msg, addr)
+		_, err = tx.Stmt(db.deleteReactions).ExecContext(ctx, msgRID, sender)
+		if err != nil {
+			return err
+		}
+		for _, reaction := range msg.Reactions.Reactions {
+			if reaction == "" {
+				continue
+			}
+			_, err = tx.Stmt(db.insertReaction).ExecContext(ctx, msgRID, sender, reaction)
+			if err != nil {
+				return err
+			}
+		}
 		return nil
 	})
+	return found, err
+}
+
+// OwnReactions returns the reactions that we have sent to the message
+// referenced by msg.Reactions.ID in the conversation with msg.To.
+func (db *DB) OwnReactions(ctx context.Context, msg event.ChatMessage, addr jid.JID) ([]string, error) {
+	var reactions []string
+	err := execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		msg.From = addr
+		msg.Sent = true
+		msgRID, err := db.referencedMsg(ctx, tx, msg, msg.Reactions.ID)
+		switch err {
+		case sql.ErrNoRows:
+			return nil
+		case nil:
+		default:
+			return err
+		}
+		rows, err := tx.Stmt(db.selectReactions).QueryContext(ctx, msgRID, reactionSender(msg, addr))
+		if err != nil {
+			return err
+		}
+		/* #nosec */
+		defer rows.Close()
+		for rows.Next() {
+			var reaction string
+			err = rows.Scan(&reaction)
+			if err != nil {
+				return err
+			}
+			reactions = append(reactions, reaction)
+		}
+		return rows.Err()
+	})
+	return reactions, err
+}
+
+// referencedMsg returns the row ID of the message with the given ID in the
+// conversation that msg belongs to.
+// In group chats the ID is the stanza ID assigned by the group chat.
+func (db *DB) referencedMsg(ctx context.Context, tx *sql.Tx, msg event.ChatMessage, id string) (uint64, error) {
+	rosterJID := msg.With().String()
+	stmt := db.selectReacted
+	if msg.Type == stanza.GroupChatMessage {
+		stmt = db.selectModerated
+	}
+	var msgRID uint64
+	err := tx.Stmt(stmt).QueryRowContext(ctx, rosterJID, id).Scan(&msgRID)
+	return msgRID, err
+}
+
+// MarkDisplayed applies a displayed chat marker (XEP-0333).
+// If we sent the marker, every message that we received in the conversation
+// up to and including the marked message has been read.
+// Otherwise every message that we sent up to and including the marked message
+// has been read by the recipient.
+// If no such message exists false is returned.
+func (db *DB) MarkDisplayed(ctx context.Context, msg event.ChatMessage, addr jid.JID) (bool, error) {
+	var found bool
+	err := execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		if msg.From.Equal(jid.JID{}) {
+			msg.From = addr
+		}
+		msgRID, err := db.referencedMsg(ctx, tx, msg, msg.Marker.ID)
+		switch err {
+		case sql.ErrNoRows:
+			return nil
+		case nil:
+		default:
+			return err
+		}
+		found = true
+		rosterJID := msg.With().String()
+		_, err = tx.Stmt(db.markDisplayed).ExecContext(ctx, rosterJID, !msg.Sent, msgRID)
+		return err
+	})
+	return found, err
+}
+
+// LastMarkable returns the last message that we received from j that can be
+// marked as displayed (XEP-0333).
+// If there is no such message, false is returned.
+func (db *DB) LastMarkable(ctx context.Context, j jid.JID) (event.ChatMessage, bool, error) {
+	var msg event.ChatMessage
+	var typ string
+	var archiveID sql.NullString
+	err := db.lastMarkable.QueryRowContext(ctx, j.String()).Scan(&msg.ID, &archiveID, &typ, &msg.Displayed)
+	switch err {
+	case sql.ErrNoRows:
+		return msg, false, nil
+	case nil:
+	default:
+		return msg, false, err
+	}
+	msg.Type = stanza.MessageType(typ)
+	msg.From = j
+	if archiveID.Valid && msg.Type == stanza.GroupChatMessage {
+		msg.SID = []stanza.ID{{ID: archiveID.String, By: j.Bare()}}
+	}
+	return msg, true, nil
+}
+
+// reactionSender returns the sender that reactions are stored under.
+// Our own reactions are always stored under our bare JID, but in group chats
+// other people's reactions are stored under their occupant JID.
+func reactionSender(msg event.ChatMessage, addr jid.JID) string {
+	switch {
+	case msg.Sent:
+		return addr.Bare().String()
+	case msg.Type == stanza.GroupChatMessage:
+		return msg.From.String()
+	}
+	return msg.From.Bare().String()
 }
 
 // ForRoster executes f for each roster entry.
@@ -481,6 +996,88 @@ func (db *DB) ForRoster(ctx context.Context, f func(event.UpdateRoster)) error {
 	})
 }
 
+// Conversation is an entry in the recent conversations list.
+type Conversation struct {
+	JID  jid.JID
+	Name string
+	Room bool
+
+	// Notify is the notification policy of the conversation (or empty for the
+	// default) and MutedUntil is the time until which it is muted, if any.
+	Notify     string
+	MutedUntil time.Time
+
+	// Unread is true if any messages have been received since the conversation
+	// was last read, and FirstUnread is the ID of the first of them.
+	Unread      bool
+	FirstUnread string
+}
+
+// ForConversations executes f for each conversation in the recent
+// conversations list in the order that they were added.
+func (db *DB) ForConversations(ctx context.Context, f func(Conversation)) error {
+	return execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		rows, err := tx.Stmt(db.selectConvs).QueryContext(ctx)
+		if err != nil {
+			return err
+		}
+		/* #nosec */
+		defer rows.Close()
+		for rows.Next() {
+			var c Conversation
+			var jidStr string
+			var name sql.NullString
+			var mutedUntil sql.NullInt64
+			err = rows.Scan(&jidStr, &name, &c.Room, &c.Notify, &mutedUntil, &c.Unread, &c.FirstUnread)
+			if err != nil {
+				return err
+			}
+			j, err := jid.ParseUnsafe(jidStr)
+			if err != nil {
+				return err
+			}
+			c.JID = j.JID
+			c.Name = name.String
+			if mutedUntil.Valid {
+				c.MutedUntil = time.Unix(mutedUntil.Int64, 0)
+			}
+			f(c)
+		}
+		return rows.Err()
+	})
+}
+
+// UpsertConversation adds a conversation to the end of the recent
+// conversations list or updates it if it already exists.
+func (db *DB) UpsertConversation(ctx context.Context, c Conversation) error {
+	return execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		var mutedUntil sql.NullInt64
+		if !c.MutedUntil.IsZero() {
+			mutedUntil = sql.NullInt64{Int64: c.MutedUntil.Unix(), Valid: true}
+		}
+		_, err := tx.Stmt(db.upsertConv).ExecContext(ctx, c.JID.String(), c.Name, c.Room, c.Notify, mutedUntil)
+		return err
+	})
+}
+
+// DeleteConversation removes a conversation from the recent conversations
+// list.
+func (db *DB) DeleteConversation(ctx context.Context, j jid.JID) error {
+	return execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.Stmt(db.deleteConv).ExecContext(ctx, j.String())
+		return err
+	})
+}
+
+// MarkConversationRead records that everything in the conversation with j up
+// to and including the latest message has been read.
+func (db *DB) MarkConversationRead(ctx context.Context, j jid.JID) error {
+	return execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.Stmt(db.readConv).ExecContext(ctx, j.String())
+		return err
+	})
+}
+
 // ReplaceRoster truncates the entire roster and replaces it with the provided
 // items.
 func (db *DB) ReplaceRoster(ctx context.Context, e event.FetchRoster) error {
@@ -572,18 +1169,47 @@ type MessageIter struct {
 	*Iter
 }
 
+type historyRow struct {
+	msg event.ChatMessage
+	id  int64
+}
+
 // Result returns the most recent result read from the iter.
 func (iter MessageIter) Message() event.ChatMessage {
 	cur := iter.Iter.Current()
 	if cur == nil {
 		return event.ChatMessage{}
 	}
-	return cur.(event.ChatMessage)
+	return cur.(historyRow).msg
 }
 
-// QueryHistory returns all rows to or from the given JID.
+// RowID returns the row ID of the most recent result read from the iter.
+// It can be used as the start or end of a Page.
+func (iter MessageIter) RowID() int64 {
+	cur := iter.Iter.Current()
+	if cur == nil {
+		return 0
+	}
+	return cur.(historyRow).id
+}
+
+// Page selects part of the history of a conversation.
+// Before and After are row IDs returned by MessageIter.RowID and the messages
+// that they refer to are not included in the page.
+// If After is set the oldest Limit messages after it are selected, otherwise
+// the newest Limit messages before Before (or the newest messages if Before is
+// not set either).
+// A Limit of 0 selects all matching messages.
+type Page struct {
+	Before int64
+	After  int64
+	Limit  int
+}
+
+// QueryHistory returns a page of rows to or from the given JID ordered from
+// oldest to newest.
 // Any errors encountered while querying are deferred until the iter is used.
-func (db *DB) QueryHistory(ctx context.Context, j string, typ stanza.MessageType) MessageIter {
+func (db *DB) QueryHistory(ctx context.Context, j string, typ stanza.MessageType, page Page) MessageIter {
 	db.txM.Lock()
 	ctx, cancel := context.WithCancel(ctx)
 	go func() {
@@ -591,7 +1217,20 @@ func (db *DB) QueryHistory(ctx context.Context, j string, typ stanza.MessageType
 		defer db.txM.Unlock()
 	}()
 
-	rows, err := db.queryMsg.QueryContext(ctx, j, string(typ))
+	limit := page.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	// Only private conversations with group chat occupants are stored under a
+	// full JID.
+	private := strings.ContainsRune(j, '/')
+	var rows *sql.Rows
+	var err error
+	if page.After != 0 {
+		rows, err = db.queryMsgAfter.QueryContext(ctx, j, string(typ), page.After, limit)
+	} else {
+		rows, err = db.queryMsg.QueryContext(ctx, j, string(typ), page.Before, limit)
+	}
 	return MessageIter{
 		Iter: &Iter{
 			cancel: cancel,
@@ -600,11 +1239,140 @@ func (db *DB) QueryHistory(ctx context.Context, j string, typ stanza.MessageType
 			f: func(rows *sql.Rows) (interface{}, error) {
 				cur := event.ChatMessage{}
 				var to, from, typ string
-				err := rows.Scan(&cur.Sent, &to, &from, &cur.ID, &cur.Body, &typ)
+				var archiveID, reactions, replyID sql.NullString
+				var replyStart, replyEnd sql.NullInt64
+				var delay, rowID int64
+				var quoted quotedRow
+				err := rows.Scan(&cur.Sent, &to, &from, &cur.ID, &cur.Body, &typ, &delay, &cur.Retracted, &archiveID, &cur.Displayed, &cur.Received, &cur.Failed, &reactions,
+					&replyID, &replyStart, &replyEnd,
+					&quoted.sent, &quoted.to, &quoted.from, &quoted.id, &quoted.body, &quoted.retracted,
+					&rowID)
+				if err != nil {
+					return historyRow{}, err
+				}
+				if replyID.Valid {
+					cur.Reply.ID = replyID.String
+					if replyStart.Valid && replyEnd.Valid {
+						cur.Fallback = []event.Fallback{{
+							For:  nsReply,
+							Body: []event.FallbackBody{{Start: int(replyStart.Int64), End: int(replyEnd.Int64)}},
+						}}
+					}
+					cur.Quoted, err = quoted.message()
+					if err != nil {
+						return historyRow{}, err
+					}
+				}
+				cur.Type = stanza.MessageType(typ)
+				if private {
+					cur.Private = &struct{}{}
+				}
+				cur.Delay.Time = time.Unix(delay, 0)
+				if reactions.Valid {
+					cur.Reactions.Reactions = strings.Split(reactions.String, "\x1f")
+				}
+				unsafeTo, err := jid.ParseUnsafe(to)
+				if err != nil {
+					return historyRow{}, err
+				}
+				cur.To = unsafeTo.JID
+				unsafeFrom, err := jid.ParseUnsafe(from)
+				if err != nil {
+					return historyRow{}, err
+				}
+				cur.From = unsafeFrom.JID
+				// Group chats are the only archive that we store stanza IDs from other
+				// than our own, and we need them to react to messages.
+				if archiveID.Valid && cur.Type == stanza.GroupChatMessage {
+					by := cur.From.Bare()
+					if cur.Sent {
+						by = cur.To.Bare()
+					}
+					cur.SID = []stanza.ID{{ID: archiveID.String, By: by}}
+				}
+				return historyRow{msg: cur, id: rowID}, nil
+			},
+		},
+	}
+}
+
+// Markers that surround the matched terms in search result snippets.
+const (
+	MatchStart = "\x02"
+	MatchEnd   = "\x03"
+)
+
+// SearchResult is a message that matched a search query.
+type SearchResult struct {
+	event.ChatMessage
+
+	// JID is the bare address of the conversation that the message belongs to.
+	JID jid.JID
+
+	// Snippet is the part of the body that matched the query with each match
+	// surrounded by MatchStart and MatchEnd.
+	Snippet string
+}
+
+// SearchIter is an iterator that can return search results.
+type SearchIter struct {
+	*Iter
+}
+
+// Result returns the most recent result read from the iter.
+func (iter SearchIter) Result() SearchResult {
+	cur := iter.Iter.Current()
+	if cur == nil {
+		return SearchResult{}
+	}
+	return cur.(SearchResult)
+}
+
+// Search returns up to limit messages with bodies that contain all of the words
+// in query, most recent first.
+// If j is the zero value all conversations are searched, otherwise only the
+// conversation with j is searched.
+// Any errors encountered while querying are deferred until the iter is used.
+func (db *DB) Search(ctx context.Context, query string, j jid.JID, limit int) SearchIter {
+	match := ftsQuery(query)
+	if match == "" {
+		// An empty FTS5 query is a syntax error, not a query that matches nothing.
+		return SearchIter{}
+	}
+	db.txM.Lock()
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		<-ctx.Done()
+		defer db.txM.Unlock()
+	}()
+
+	var conv string
+	if !j.Equal(jid.JID{}) {
+		conv = j.String()
+	}
+	rows, err := db.searchMsg.QueryContext(ctx, match, conv, limit)
+	return SearchIter{
+		Iter: &Iter{
+			cancel: cancel,
+			err:    err,
+			rows:   rows,
+			f: func(rows *sql.Rows) (interface{}, error) {
+				cur := SearchResult{}
+				var rosterJID, to, from, typ string
+				var id, archiveID sql.NullString
+				var delay int64
+				err := rows.Scan(&rosterJID, &cur.Sent, &to, &from, &id, &archiveID, &typ, &delay, &cur.Snippet)
 				if err != nil {
 					return cur, err
 				}
+				cur.ID = id.String
 				cur.Type = stanza.MessageType(typ)
+				cur.Delay.Time = time.Unix(delay, 0)
+				unsafeJID, err := jid.ParseUnsafe(rosterJID)
+				if err != nil {
+					return cur, err
+				}
+				cur.JID = unsafeJID.JID
 				unsafeTo, err := jid.ParseUnsafe(to)
 				if err != nil {
 					return cur, err
@@ -615,12 +1383,105 @@ func (db *DB) QueryHistory(ctx context.Context, j string, typ stanza.MessageType
 					return cur, err
 				}
 				cur.From = unsafeFrom.JID
+				if archiveID.Valid && cur.Type == stanza.GroupChatMessage {
+					cur.SID = []stanza.ID{{ID: archiveID.String, By: cur.JID}}
+				}
 				return cur, nil
 			},
 		},
 	}
 }
 
+// ftsQuery turns the words in a user entered search into an FTS5 query that
+// matches messages containing all of them.
+// Each word is quoted so that any FTS5 syntax in the search is treated as part
+// of the text to find, and the last word matches as a prefix so that results
+// can be shown while the user is still typing.
+func ftsQuery(query string) string {
+	words := strings.Fields(query)
+	for i, word := range words {
+		words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+	if len(words) > 0 {
+		words[len(words)-1] += "*"
+	}
+	return strings.Join(words, " ")
+}
+
+// nsReply is the namespace used by Message Replies (XEP-0461).
+const nsReply = "urn:xmpp:reply:0"
+
+// replyFallback returns the range of the body that is a fallback for a reply,
+// or nil if there is none.
+func replyFallback(msg event.ChatMessage) (start, end *int) {
+	if msg.Reply.ID == "" {
+		return nil, nil
+	}
+	for _, f := range msg.Fallback {
+		if f.For == nsReply && len(f.Body) > 0 {
+			return &f.Body[0].Start, &f.Body[0].End
+		}
+	}
+	return nil, nil
+}
+
+// quotedRow is a message that might be missing from the results of a query.
+type quotedRow struct {
+	sent      sql.NullBool
+	to        sql.NullString
+	from      sql.NullString
+	id        sql.NullString
+	body      sql.NullString
+	retracted sql.NullBool
+}
+
+// message returns the quoted message, or nil if it was not found.
+func (q quotedRow) message() (*event.ChatMessage, error) {
+	if !q.sent.Valid {
+		return nil, nil
+	}
+	msg := &event.ChatMessage{
+		Body:      q.body.String,
+		Sent:      q.sent.Bool,
+		Retracted: q.retracted.Bool,
+	}
+	msg.ID = q.id.String
+	unsafeTo, err := jid.ParseUnsafe(q.to.String)
+	if err != nil {
+		return nil, err
+	}
+	msg.To = unsafeTo.JID
+	unsafeFrom, err := jid.ParseUnsafe(q.from.String)
+	if err != nil {
+		return nil, err
+	}
+	msg.From = unsafeFrom.JID
+	return msg, nil
+}
+
+// QuotedMsg returns the message that msg replies to (XEP-0461), or nil if it
+// could not be found.
+func (db *DB) QuotedMsg(ctx context.Context, msg event.ChatMessage, addr jid.JID) (*event.ChatMessage, error) {
+	if msg.Reply.ID == "" {
+		return nil, nil
+	}
+	if msg.From.Equal(jid.JID{}) {
+		msg.From = addr
+	}
+	rosterJID := msg.With().String()
+	var quoted quotedRow
+	err := db.selectQuoted.QueryRowContext(ctx, rosterJID, msg.Reply.ID, string(msg.Type)).Scan(
+		&quoted.sent, &quoted.to, &quoted.from, &quoted.id, &quoted.body, &quoted.retracted)
+	switch err {
+	case sql.ErrNoRows:
+		return nil, nil
+	case nil:
+	default:
+		return nil, err
+	}
+	return quoted.message()
+}
+
 // AfterIDRes is returned from an AfterID query.
 type AfterIDResult struct {
 	Addr  jid.JID
@@ -680,21 +1541,32 @@ func (db *DB) AfterID(ctx context.Context) AfterIDIter {
 	}
 }
 
+// LastID gets the last known message ID and timestamp for the given JID.
+// It is like AfterID except that it works for any JID (such as a group chat),
+// not just those in the roster.
+func (db *DB) LastID(ctx context.Context, j jid.JID) (string, time.Time, error) {
+	return db.firstOrLastID(ctx, db.lastID, j)
+}
+
 // BeforeID gets the first known message ID and timestamp for the given JID.
 func (db *DB) BeforeID(ctx context.Context, j jid.JID) (string, time.Time, error) {
-	var id string
-	var timestamp int64
+	return db.firstOrLastID(ctx, db.beforeID, j)
+}
+
+func (db *DB) firstOrLastID(ctx context.Context, stmt *sql.Stmt, j jid.JID) (string, time.Time, error) {
+	var id sql.NullString
+	var timestamp sql.NullInt64
 	err := execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
-		return tx.Stmt(db.beforeID).QueryRowContext(ctx, j.String()).Scan(&id, &timestamp)
+		return tx.Stmt(stmt).QueryRowContext(ctx, j.String()).Scan(&id, &timestamp)
 	})
 	if err == sql.ErrNoRows {
 		err = nil
 	}
 	var t time.Time
-	if timestamp != 0 {
-		t = time.Unix(timestamp, 0)
+	if timestamp.Valid && timestamp.Int64 != 0 {
+		t = time.Unix(timestamp.Int64, 0)
 	}
-	return id, t, err
+	return id.String, t, err
 }
 
 // InsertCaps adds a newly seen entity capbailities hash to the databsae.
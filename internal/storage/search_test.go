@@ -0,0 +1,38 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"strconv"
+	"testing"
+)
+
+var ftsQueryTestCases = [...]struct {
+	in  string
+	out string
+}{
+	0:  {},
+	1:  {in: " \t\n"},
+	2:  {in: "foo", out: `"foo"*`},
+	3:  {in: "  foo   bar ", out: `"foo" "bar"*`},
+	4:  {in: `"foo`, out: `"""foo"*`},
+	5:  {in: `say "foo bar"`, out: `"say" """foo" "bar"""*`},
+	6:  {in: "foo OR bar", out: `"foo" "OR" "bar"*`},
+	7:  {in: "foo AND NOT bar", out: `"foo" "AND" "NOT" "bar"*`},
+	8:  {in: "NEAR(foo bar)", out: `"NEAR(foo" "bar)"*`},
+	9:  {in: "body:foo", out: `"body:foo"*`},
+	10: {in: "-foo ^bar * (", out: `"-foo" "^bar" "*" "("*`},
+}
+
+func TestFTSQuery(t *testing.T) {
+	for i, tc := range ftsQueryTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			out := ftsQuery(tc.in)
+			if out != tc.out {
+				t.Errorf("wrong query: want=%s, got=%s", tc.out, out)
+			}
+		})
+	}
+}
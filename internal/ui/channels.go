@@ -0,0 +1,151 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package ui
+
+import (
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+
+	"mellium.im/communique/internal/ui/event"
+	"mellium.im/xmpp/jid"
+)
+
+// ShowCreateChannel asks the user for the address of a new group chat.
+func (ui *UI) ShowCreateChannel() {
+	const pageName = "create_channel"
+	p := ui.Printer()
+	createButton := p.Sprintf("Create")
+	// Autocomplete the domains of group chats that we already know about since
+	// new group chats will most likely be on the same service.
+	autocomplete := make([]jid.JID, 0, len(ui.sidebar.bookmarks.items))
+	for _, item := range ui.sidebar.bookmarks.items {
+		autocomplete = append(autocomplete, item.JID.Domain())
+	}
+	mod := getJID(p, p.Sprintf("Create Channel"), createButton, true, func(j jid.JID, buttonLabel string) {
+		if buttonLabel == createButton {
+			ui.handler(event.CreateChannel(j))
+		}
+		ui.pages.HidePage(pageName)
+		ui.pages.RemovePage(pageName)
+	}, autocomplete)
+
+	ui.pages.AddPage(pageName, mod, true, true)
+	ui.pages.ShowPage(pageName)
+	ui.pages.SendToFront(pageName)
+	ui.app.SetFocus(ui.pages)
+}
+
+// ShowChannelCreated asks the user whether a group chat that was just created
+// should use the default configuration or be configured first.
+func (ui *UI) ShowChannelCreated(room jid.JID) {
+	const pageName = "channel_created"
+	p := ui.Printer()
+	var (
+		configureButton = p.Sprintf("Configure")
+		defaultsButton  = p.Sprintf("Use Defaults")
+	)
+	// The group chat stays locked until one of the buttons is picked, so there is
+	// no way to close the modal without picking one.
+	mod := NewModal().
+		SetText(p.Sprintf("The channel %s has been created. Configure it now or use the default configuration?", room)).
+		AddButtons([]string{configureButton, defaultsButton}).
+		SetDoneFunc(func(_ int, buttonLabel string) {
+			switch buttonLabel {
+			case configureButton:
+				ui.handler(event.ConfigureChannel{JID: room, Created: true})
+			case defaultsButton:
+				ui.handler(event.AcceptChannelDefaults(room))
+			default:
+				return
+			}
+			ui.pages.HidePage(pageName)
+			ui.pages.RemovePage(pageName)
+		})
+	ui.pages.AddPage(pageName, mod, true, false)
+	ui.pages.ShowPage(pageName)
+	ui.pages.SendToFront(pageName)
+	ui.app.SetFocus(ui.pages)
+	ui.redraw()
+}
+
+// configureChannel asks to configure the group chat that is selected in the
+// sidebar.
+func (s *Sidebar) configureChannel() {
+	v, ok := s.GetSelected()
+	if !ok {
+		return
+	}
+	switch item := v.(type) {
+	case BookmarkItem:
+		s.ui.handler(event.ConfigureChannel{JID: item.JID.Bare()})
+	case Conversation:
+		if item.Room {
+			s.ui.handler(event.ConfigureChannel{JID: item.JID.Bare()})
+		}
+	}
+}
+
+// ShowChangeNick asks the user for a new nickname to use in a group chat.
+func (ui *UI) ShowChangeNick(room jid.JID) {
+	const pageName = "change_nick"
+	p := ui.Printer()
+	var (
+		cancelButton = p.Sprintf("Cancel")
+		changeButton = p.Sprintf("Change")
+	)
+	var nick string
+	if o, ok := ui.history.occupants.self(room); ok {
+		nick = o.JID.Resourcepart()
+	}
+	onEsc := func() {
+		ui.pages.HidePage(pageName)
+		ui.pages.RemovePage(pageName)
+	}
+	mod := NewModal().
+		SetText(p.Sprintf("Change nickname in %s", room))
+	modForm := mod.Form()
+	modForm.AddFormItem(tview.NewInputField().
+		SetLabel(p.Sprintf("Nickname")).
+		SetText(nick).
+		SetChangedFunc(func(text string) {
+			nick = text
+		}))
+	mod.AddButtons([]string{cancelButton, changeButton}).
+		SetDoneFunc(func(_ int, buttonLabel string) {
+			if buttonLabel == changeButton {
+				nick = strings.TrimSpace(nick)
+				if nick == "" {
+					return
+				}
+				ui.handler(event.ChangeNick{Room: room.Bare(), Nick: nick})
+			}
+			onEsc()
+		})
+	// Don't use modalClose because we don't want typing a "q" in the nickname
+	// field to close the modal.
+	mod.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyESC {
+			onEsc()
+		}
+		return event
+	})
+	ui.pages.AddPage(pageName, mod, true, false)
+	ui.pages.ShowPage(pageName)
+	ui.pages.SendToFront(pageName)
+	ui.app.SetFocus(ui.pages)
+}
+
+// ChangeBookmarkNick saves the nickname that we use in a group chat to its
+// bookmark, if it is bookmarked.
+func (ui *UI) ChangeBookmarkNick(room jid.JID, nick string) {
+	item, ok := ui.sidebar.bookmarks.GetItem(room.Bare().String())
+	if !ok || item.Nick == nick {
+		return
+	}
+	item.Channel.Nick = nick
+	ui.UpdateBookmarks(item.Channel)
+}
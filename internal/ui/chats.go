@@ -5,11 +5,17 @@
 package ui
 
 import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
 
 	"mellium.im/communique/internal/ui/event"
 	"mellium.im/filechooser"
+	"mellium.im/xmpp/jid"
 	"mellium.im/xmpp/stanza"
 )
 
@@ -22,13 +28,81 @@ type ConversationView struct {
 	*tview.Flex
 	TextView   *tview.TextView
 	inputPages *tview.Pages
+	body       *tview.Flex
+	occupants  *occupantList
 	ui         *UI
+	states     *chatStates
+	sentM      sync.Mutex
+	lastSent   map[string]sentMsg
+	editing    string
+	replying   *Message
+	// editJID is the conversation that editing or replying belongs to.
+	editJID  jid.JID
+	msgM     sync.Mutex
+	msgs     map[string]Message
+	regions  []string
+	selected int
+	lastDay  time.Time
+	cursor   int64
+}
+
+// Message is a message that has been written to the conversation view and
+// that can be selected.
+type Message struct {
+	// ID is the ID of the message.
+	ID string
+	// StanzaID is the ID assigned to the message by a group chat, if any.
+	StanzaID string
+	// JID is the address of the conversation that the message belongs to.
+	JID jid.JID
+	// From is the address of the author of the message, if it is known.
+	From      jid.JID
+	Type      stanza.MessageType
+	Sent      bool
+	Retracted bool
+	Receipt   ReceiptStatus
+}
+
+// ReceiptStatus is the delivery status of a message that we sent.
+type ReceiptStatus int
+
+// A list of possible delivery statuses.
+const (
+	// ReceiptNone is used for messages that we don't track the delivery of (eg.
+	// received messages or messages sent to group chats).
+	ReceiptNone ReceiptStatus = iota
+	ReceiptPending
+	ReceiptDelivered
+	ReceiptFailed
+)
+
+// ReceiptIndicator returns the text that should be written next to a sent
+// message to show its delivery status.
+// The indicator can later be changed with SetReceipt.
+func ReceiptIndicator(status ReceiptStatus) string {
+	switch status {
+	case ReceiptPending:
+		return "[::d]·[::-]"
+	case ReceiptDelivered:
+		return "[green]✓[-]"
+	case ReceiptFailed:
+		return "[red]✗[-]"
+	}
+	return ""
+}
+
+// sentMsg is the last message that we sent in a conversation.
+type sentMsg struct {
+	id   string
+	body string
 }
 
 const (
 	pageFilePicker  = "page_filepick"
 	pageInput       = "page_input"
 	filePickerLabel = "📎"
+	editLabel       = "✎ "
+	replyLabel      = "↩ "
 )
 
 // NewConversationView configures and creates a new chat view.
@@ -42,7 +116,17 @@ func NewConversationView(ui *UI) *ConversationView {
 			ScrollToEnd().
 			Highlight(UnreadRegion),
 		inputPages: tview.NewPages(),
+		body:       tview.NewFlex(),
+		occupants:  newOccupantList(p),
 		ui:         ui,
+		lastSent:   make(map[string]sentMsg),
+		msgs:       make(map[string]Message),
+		selected:   -1,
+		states: &chatStates{
+			handler: func(e interface{}) {
+				ui.handler(e)
+			},
+		},
 	}
 	filePicker := filechooser.NewPathInputField()
 	filePicker.SetFieldBackgroundColor(tview.Styles.PrimitiveBackgroundColor)
@@ -63,7 +147,10 @@ func NewConversationView(ui *UI) *ConversationView {
 	cv.inputPages.AddPage(pageFilePicker, filePicker, true, false)
 	cv.inputPages.AddPage(pageInput, input, true, true)
 	cv.Flex.SetBorder(false)
-	cv.Flex.AddItem(unreadTextView{TextView: cv.TextView}, 0, 100, false)
+	cv.body.AddItem(unreadTextView{TextView: cv.TextView}, 0, 1, false)
+	// The occupant list is only given room when a group chat is open.
+	cv.body.AddItem(cv.occupants, 0, 0, false)
+	cv.Flex.AddItem(cv.body, 0, 100, false)
 	cv.Flex.AddItem(cv.inputPages, 3, 1, true)
 	cv.TextView.SetChangedFunc(func() {
 		ui.app.Draw()
@@ -71,6 +158,30 @@ func NewConversationView(ui *UI) *ConversationView {
 	return &cv
 }
 
+// Draw implements tview.Primitive and updates the title to show whether the
+// person we're chatting with is typing.
+func (cv *ConversationView) Draw(screen tcell.Screen) {
+	p := cv.ui.Printer()
+	title := p.Sprintf("Conversation")
+	c, ok := cv.ui.sidebar.conversations.GetSelected()
+	if ok && !c.Room {
+		switch c.chatState {
+		case stateComposing:
+			title = p.Sprintf("Conversation (%s is typing…)", tview.Escape(c.Name))
+		case statePaused:
+			title = p.Sprintf("Conversation (%s stopped typing)", tview.Escape(c.Name))
+		}
+	}
+	cv.TextView.SetTitle(title)
+	if ok && c.Room && cv.occupants.visible {
+		cv.occupants.show(c.JID)
+		cv.body.ResizeItem(cv.occupants, occupantsWidth, 0)
+	} else {
+		cv.body.ResizeItem(cv.occupants, 0, 0)
+	}
+	cv.Flex.Draw(screen)
+}
+
 // ShowFilePicker shows the file picker field.
 func (cv *ConversationView) ShowFilePicker() {
 	cv.inputPages.SwitchToPage(pageFilePicker)
@@ -148,19 +259,48 @@ func (cv *ConversationView) InputHandler() func(event *tcell.EventKey, setFocus
 			return
 		}
 
+		if cv.occupants.HasFocus() {
+			cv.occupantsKey(ev, setFocus)
+			return
+		}
+
 		switch ev.Key() {
-		case tcell.KeyUp, tcell.KeyDown, tcell.KeyRight, tcell.KeyLeft, tcell.KeyPgUp, tcell.KeyPgDn:
+		case tcell.KeyUp:
+			if cv.inputPages.HasFocus() && pageName == pageInput && cv.editLast() {
+				break
+			}
+			if !cv.inputPages.HasFocus() && cv.moveSelection(-1) {
+				break
+			}
+			cv.TextView.InputHandler()(ev, setFocus)
+		case tcell.KeyDown:
+			if !cv.inputPages.HasFocus() && cv.moveSelection(1) {
+				break
+			}
+			cv.TextView.InputHandler()(ev, setFocus)
+		case tcell.KeyRight, tcell.KeyLeft, tcell.KeyPgUp, tcell.KeyPgDn:
 			cv.TextView.InputHandler()(ev, setFocus)
 		case tcell.KeyTAB, tcell.KeyBacktab:
-			if cv.inputPages.HasFocus() {
+			switch {
+			case cv.inputPages.HasFocus() && cv.occupantsShown():
+				setFocus(cv.occupants)
+			case cv.inputPages.HasFocus():
 				setFocus(cv.TextView)
-			} else {
+			default:
 				setFocus(cv.inputPages)
 			}
 		case tcell.KeyESC:
+			if cv.clearSelection() {
+				break
+			}
+			if cv.ui.chatStates {
+				cv.states.leave()
+			}
+			cv.resetEdit()
 			cv.ui.SelectRoster()
 		case tcell.KeyEnter:
 			if !cv.inputPages.HasFocus() {
+				cv.startSelection()
 				break
 			}
 			sendMsg(cv, ev, setFocus)
@@ -182,7 +322,19 @@ func (cv *ConversationView) InputHandler() func(event *tcell.EventKey, setFocus
 			// Pass anything else to the input handler.
 			if cv.inputPages.HasFocus() {
 				cv.inputPages.InputHandler()(ev, setFocus)
-			} else {
+				if pageName == pageInput {
+					cv.cancelEdit(false)
+					cv.typing()
+				}
+			} else if ev.Key() == tcell.KeyRune && ev.Rune() == '/' {
+				cv.ui.ShowSearch(cv.ui.GetRosterJID())
+			} else if ev.Key() == tcell.KeyRune && ev.Rune() == 'o' {
+				cv.occupants.visible = !cv.occupants.visible
+			} else if ev.Key() == tcell.KeyRune && ev.Rune() == 'i' && cv.inChannel() {
+				cv.ui.ShowInvite(cv.ui.GetRosterJID())
+			} else if ev.Key() == tcell.KeyRune && ev.Rune() == 'n' && cv.inChannel() {
+				cv.ui.ShowChangeNick(cv.ui.GetRosterJID())
+			} else if !cv.selectionKey(ev, setFocus) {
 				checkScroll(cv, func() {
 					cv.TextView.InputHandler()(ev, setFocus)
 				})
@@ -191,6 +343,256 @@ func (cv *ConversationView) InputHandler() func(event *tcell.EventKey, setFocus
 	}
 }
 
+// inChannel reports whether the selected conversation is a group chat.
+func (cv *ConversationView) inChannel() bool {
+	c, ok := cv.ui.sidebar.conversations.GetSelected()
+	return ok && c.Room
+}
+
+// occupantsShown reports whether the occupant list is shown next to the
+// conversation.
+func (cv *ConversationView) occupantsShown() bool {
+	return cv.inChannel() && cv.occupants.visible
+}
+
+// occupantsKey handles key presses while the occupant list has focus.
+func (cv *ConversationView) occupantsKey(ev *tcell.EventKey, setFocus func(p tview.Primitive)) {
+	switch {
+	case ev.Key() == tcell.KeyTAB, ev.Key() == tcell.KeyBacktab, ev.Key() == tcell.KeyESC:
+		setFocus(cv.TextView)
+	case ev.Key() == tcell.KeyEnter, ev.Key() == tcell.KeyRune && ev.Rune() == 'I':
+		if o, ok := cv.occupants.selected(); ok {
+			cv.ui.ShowOccupantInfo(o)
+		}
+	case ev.Key() == tcell.KeyRune && ev.Rune() == 'm':
+		if o, ok := cv.occupants.selected(); ok && !o.Self {
+			cv.ui.OpenPrivateChat(o)
+		}
+	default:
+		if o, ok := cv.occupants.selected(); ok && cv.ui.moderateKey(o, ev) {
+			break
+		}
+		cv.occupants.InputHandler()(ev, setFocus)
+	}
+}
+
+// writeRegion records a message that is about to be written to the
+// conversation view and returns the region that it should be wrapped in.
+func (cv *ConversationView) writeRegion(m Message) string {
+	cv.msgM.Lock()
+	defer cv.msgM.Unlock()
+	region := "msg" + strconv.Itoa(len(cv.regions))
+	cv.msgs[region] = m
+	cv.regions = append(cv.regions, region)
+	return region
+}
+
+// clear removes all messages from the conversation view.
+func (cv *ConversationView) clear() {
+	cv.msgM.Lock()
+	cv.msgs = make(map[string]Message)
+	cv.regions = cv.regions[:0]
+	cv.selected = -1
+	cv.lastDay = time.Time{}
+	cv.cursor = 0
+	cv.msgM.Unlock()
+	cv.TextView.Highlight(UnreadRegion)
+	cv.TextView.SetText("")
+}
+
+// wrappedLines returns the number of lines that the conversation takes up once
+// it has been wrapped to the width of the view.
+func (cv *ConversationView) wrappedLines() int {
+	_, _, width, _ := cv.TextView.GetInnerRect()
+	// Measure a copy of the text so that we don't disturb the index of wrapped
+	// lines that the view keeps for the width that it was last drawn at.
+	measure := tview.NewTextView().
+		SetDynamicColors(true).
+		SetRegions(true).
+		SetSize(0, width)
+	measure.SetText(cv.TextView.GetText(false))
+	return measure.GetWrappedLineCount()
+}
+
+// daySeparator returns a line showing the date of t if it is on a different
+// day than the last message written to the conversation view.
+func (cv *ConversationView) daySeparator(t time.Time) string {
+	t = t.Local()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+	cv.msgM.Lock()
+	defer cv.msgM.Unlock()
+	if day.Equal(cv.lastDay) {
+		return ""
+	}
+	cv.lastDay = day
+	// The unread marker is found by looking for a line that starts with ─, so
+	// don't use it here.
+	return "[::d]┄┄ " + tview.Escape(day.Format("Monday, 2 January 2006")) + " ┄┄[::-]\n"
+}
+
+// setReceipt updates the delivery status shown next to a message that we sent
+// without reloading the entire conversation.
+// It reports whether the message was found.
+func (cv *ConversationView) setReceipt(id string, status ReceiptStatus) bool {
+	cv.msgM.Lock()
+	defer cv.msgM.Unlock()
+	for region, m := range cv.msgs {
+		if m.ID != id || !m.Sent || m.Receipt == ReceiptNone {
+			continue
+		}
+		if m.Receipt == status {
+			return true
+		}
+		text := cv.TextView.GetText(false)
+		start := strings.Index(text, `["`+region+`"]`)
+		if start < 0 {
+			return false
+		}
+		old := ReceiptIndicator(m.Receipt)
+		idx := strings.Index(text[start:], old)
+		if idx < 0 {
+			return false
+		}
+		idx += start
+		cv.TextView.SetText(text[:idx] + ReceiptIndicator(status) + text[idx+len(old):])
+		m.Receipt = status
+		cv.msgs[region] = m
+		return true
+	}
+	return false
+}
+
+// startSelection selects the last message in the conversation if no message is
+// selected yet.
+func (cv *ConversationView) startSelection() {
+	cv.msgM.Lock()
+	defer cv.msgM.Unlock()
+	if cv.selected >= 0 || len(cv.regions) == 0 {
+		return
+	}
+	cv.selectLocked(len(cv.regions) - 1)
+}
+
+// moveSelection moves the selection up (negative) or down (positive) by delta
+// messages.
+// It reports whether a message was selected.
+func (cv *ConversationView) moveSelection(delta int) bool {
+	cv.msgM.Lock()
+	defer cv.msgM.Unlock()
+	if cv.selected < 0 {
+		return false
+	}
+	idx := min(max(cv.selected+delta, 0), len(cv.regions)-1)
+	cv.selectLocked(idx)
+	return true
+}
+
+func (cv *ConversationView) selectLocked(idx int) {
+	cv.selected = idx
+	cv.TextView.Highlight(cv.regions[idx])
+	// Don't use the wrapping method to avoid triggering pull to refresh.
+	cv.TextView.ScrollToHighlight()
+}
+
+// selectMessage selects the last message with the given ID or group chat
+// stanza ID.
+// It reports whether the message was found.
+func (cv *ConversationView) selectMessage(id string) bool {
+	cv.msgM.Lock()
+	defer cv.msgM.Unlock()
+	if id == "" {
+		return false
+	}
+	for i := len(cv.regions) - 1; i >= 0; i-- {
+		m := cv.msgs[cv.regions[i]]
+		if m.ID == id || m.StanzaID == id {
+			cv.selectLocked(i)
+			return true
+		}
+	}
+	return false
+}
+
+// clearSelection stops selecting messages.
+// It reports whether a message was selected.
+func (cv *ConversationView) clearSelection() bool {
+	cv.msgM.Lock()
+	defer cv.msgM.Unlock()
+	if cv.selected < 0 {
+		return false
+	}
+	cv.selected = -1
+	cv.TextView.Highlight(UnreadRegion)
+	return true
+}
+
+// selectedMessage returns the currently selected message, if any.
+func (cv *ConversationView) selectedMessage() (Message, bool) {
+	cv.msgM.Lock()
+	defer cv.msgM.Unlock()
+	if cv.selected < 0 {
+		return Message{}, false
+	}
+	m, ok := cv.msgs[cv.regions[cv.selected]]
+	return m, ok
+}
+
+// selectionKey handles keys that act on the selected message.
+// It reports whether the key was handled.
+func (cv *ConversationView) selectionKey(ev *tcell.EventKey, setFocus func(p tview.Primitive)) bool {
+	if ev.Key() != tcell.KeyRune {
+		return false
+	}
+	switch ev.Rune() {
+	case 'k':
+		return cv.moveSelection(-1)
+	case 'j':
+		return cv.moveSelection(1)
+	case '+':
+		m, ok := cv.selectedMessage()
+		if !ok || m.Retracted {
+			return ok
+		}
+		id := replyID(m)
+		if id == "" {
+			return true
+		}
+		cv.ui.showReactions(func(reaction string) {
+			cv.ui.handler(event.React{
+				To:       m.JID,
+				Type:     m.Type,
+				ID:       id,
+				Reaction: reaction,
+			})
+		})
+		return true
+	case 'r':
+		m, ok := cv.selectedMessage()
+		if !ok || m.Retracted || replyID(m) == "" {
+			return ok
+		}
+		cv.reply(m)
+		cv.clearSelection()
+		setFocus(cv.inputPages)
+		return true
+	case 'D':
+		m, ok := cv.selectedMessage()
+		// Only messages that we sent can be retracted, and in group chats they are
+		// referenced by the stanza ID that the group chat assigned.
+		id := replyID(m)
+		if !ok || !m.Sent || m.Retracted || id == "" {
+			return ok
+		}
+		cv.ui.handler(event.RetractMessage{
+			To:   m.JID,
+			Type: m.Type,
+			ID:   id,
+		})
+		return true
+	}
+	return false
+}
+
 func sendMsg(cv *ConversationView, ev *tcell.EventKey, setFocus func(p tview.Primitive)) {
 	_, prim := cv.inputPages.GetFrontPage()
 	body := prim.(*tview.InputField).GetText()
@@ -201,22 +603,147 @@ func sendMsg(cv *ConversationView, ev *tcell.EventKey, setFocus func(p tview.Pri
 	if !ok {
 		return
 	}
+	if c.Room && cv.ui.channelCommand(c.JID, body) {
+		prim.(*tview.InputField).SetText("")
+		return
+	}
 	typ := stanza.ChatMessage
 	to := c.JID
 	if c.Room {
 		typ = stanza.GroupChatMessage
 		to = to.Bare()
 	}
-	cv.ui.handler(event.ChatMessage{
+	msg := event.ChatMessage{
 		Message: stanza.Message{
 			To:   to,
 			Type: typ,
 		},
 		Body: body,
-	})
+	}
+	cv.sentM.Lock()
+	// Never correct or reply to a message in a different conversation.
+	if cv.editJID.Equal(c.JID) {
+		msg.Replace = cv.editing
+		if cv.replying != nil {
+			msg.ReplyID = replyID(*cv.replying)
+			msg.ReplyTo = cv.replying.From
+		}
+	}
+	cv.sentM.Unlock()
+	cv.cancelEdit(true)
+	if cv.ui.chatStates && !c.Room {
+		msg.ChatState = stateActive
+		cv.states.set(to, stateActive, false)
+	}
+	cv.ui.handler(msg)
+	prim.(*tview.InputField).SetText("")
+}
+
+// SetLastSent records the last message that we sent to the given JID so that
+// it can be corrected later.
+func (cv *ConversationView) SetLastSent(j jid.JID, id, body string) {
+	cv.sentM.Lock()
+	defer cv.sentM.Unlock()
+	cv.lastSent[j.String()] = sentMsg{id: id, body: body}
+}
+
+// editLast starts correcting the last message that we sent in the selected
+// conversation if nothing has been typed yet.
+// It reports whether there was a message to edit.
+func (cv *ConversationView) editLast() bool {
+	_, prim := cv.inputPages.GetFrontPage()
+	input := prim.(*tview.InputField)
+	if input.GetText() != "" {
+		return false
+	}
+	c, ok := cv.ui.sidebar.conversations.GetSelected()
+	if !ok || c.Room {
+		return false
+	}
+	cv.sentM.Lock()
+	defer cv.sentM.Unlock()
+	last, ok := cv.lastSent[c.JID.String()]
+	if !ok || last.id == "" {
+		return false
+	}
+	cv.editing = last.id
+	cv.replying = nil
+	cv.editJID = c.JID
+	input.SetLabel(editLabel)
+	input.SetText(last.body)
+	return true
+}
+
+// cancelEdit stops correcting or replying to a message if the input field has
+// been cleared or force is true.
+func (cv *ConversationView) cancelEdit(force bool) {
+	_, prim := cv.inputPages.GetFrontPage()
+	input := prim.(*tview.InputField)
+	if !force && input.GetText() != "" {
+		return
+	}
+	cv.sentM.Lock()
+	defer cv.sentM.Unlock()
+	if cv.editing == "" && cv.replying == nil {
+		return
+	}
+	cv.editing = ""
+	cv.replying = nil
+	cv.editJID = jid.JID{}
+	input.SetLabel("")
+}
+
+// resetEdit stops correcting or replying to a message and clears the text of
+// the correction or reply so that it isn't sent to another conversation.
+func (cv *ConversationView) resetEdit() {
+	cv.sentM.Lock()
+	active := cv.editing != "" || cv.replying != nil
+	cv.sentM.Unlock()
+	if !active {
+		return
+	}
+	cv.cancelEdit(true)
+	_, prim := cv.inputPages.GetFrontPage()
 	prim.(*tview.InputField).SetText("")
 }
 
+// reply starts replying to the given message.
+func (cv *ConversationView) reply(m Message) {
+	_, prim := cv.inputPages.GetFrontPage()
+	input := prim.(*tview.InputField)
+	cv.sentM.Lock()
+	defer cv.sentM.Unlock()
+	cv.editing = ""
+	cv.replying = &m
+	cv.editJID = m.JID
+	input.SetLabel(replyLabel)
+}
+
+// replyID returns the ID that replies and reactions should use to reference
+// the message.
+// Messages in group chats are referenced by the ID assigned by the group chat
+// since the original ID may not be unique.
+func replyID(m Message) string {
+	if m.Type == stanza.GroupChatMessage {
+		return m.StanzaID
+	}
+	return m.ID
+}
+
+// typing is called after the text in the message input field may have changed
+// and sends chat state notifications if they are enabled.
+func (cv *ConversationView) typing() {
+	if !cv.ui.chatStates {
+		return
+	}
+	c, ok := cv.ui.sidebar.conversations.GetSelected()
+	if !ok || c.Room {
+		return
+	}
+	_, prim := cv.inputPages.GetFrontPage()
+	cv.states.typing(c.JID, prim.(*tview.InputField).GetText() == "")
+}
+
 func (cv *ConversationView) uploadFiles(files []string) {
 	p := cv.ui.Printer()
 
@@ -244,6 +771,108 @@ func (cv *ConversationView) uploadFiles(files []string) {
 	}
 }
 
+// Chat states defined by XEP-0085.
+const (
+	stateActive    = "active"
+	stateComposing = "composing"
+	statePaused    = "paused"
+	stateInactive  = "inactive"
+)
+
+const (
+	// pausedAfter is how long after the user stops typing that we tell the other
+	// party that they have paused.
+	pausedAfter = 5 * time.Second
+
+	// inactiveAfter is how long after the user stops interacting with a
+	// conversation that we tell the other party that they are inactive.
+	inactiveAfter = 2 * time.Minute
+)
+
+// chatStates tracks the last chat state notification that we sent so that we
+// only send a new notification when the state actually changes.
+type chatStates struct {
+	m       sync.Mutex
+	handler func(interface{})
+	to      jid.JID
+	state   string
+	timer   *time.Timer
+}
+
+// set changes our chat state in the conversation with the given address.
+// If send is false the state is changed without sending a notification (eg.
+// because it was already included in a message).
+// If the address is different from the last one that we sent a state to, the
+// previous conversation is told that we are inactive.
+func (s *chatStates) set(to jid.JID, state string, send bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.setLocked(to, state, send)
+}
+
+func (s *chatStates) setLocked(to jid.JID, state string, send bool) {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if !s.to.Equal(to) {
+		if s.state != "" && s.state != stateInactive {
+			s.handler(event.ChatState{To: s.to, State: stateInactive})
+		}
+		s.state = ""
+	}
+	if send && s.state != state {
+		s.handler(event.ChatState{To: to, State: state})
+	}
+	s.to = to
+	s.state = state
+
+	var next string
+	var after time.Duration
+	switch state {
+	case stateComposing:
+		next, after = statePaused, pausedAfter
+	case stateActive, statePaused:
+		next, after = stateInactive, inactiveAfter
+	default:
+		return
+	}
+	s.timer = time.AfterFunc(after, func() {
+		s.m.Lock()
+		defer s.m.Unlock()
+		// If the state changed after the timer fired but before we acquired the
+		// lock there is nothing left to do.
+		if !s.to.Equal(to) || s.state != state {
+			return
+		}
+		s.setLocked(to, next, true)
+	})
+}
+
+// typing updates our chat state after the message being composed changes.
+func (s *chatStates) typing(to jid.JID, empty bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	switch {
+	case !empty:
+		s.setLocked(to, stateComposing, true)
+	case s.to.Equal(to) && (s.state == stateComposing || s.state == statePaused):
+		// The user deleted everything they were typing.
+		s.setLocked(to, stateActive, true)
+	}
+}
+
+// leave tells the last conversation that we sent a chat state to that we are
+// no longer active in it.
+func (s *chatStates) leave() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.state == "" || s.state == stateInactive {
+		return
+	}
+	s.setLocked(s.to, stateInactive, true)
+}
+
 type unreadTextView struct {
 	*tview.TextView
 }
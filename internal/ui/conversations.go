@@ -5,8 +5,10 @@
 package ui
 
 import (
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
@@ -16,6 +18,9 @@ import (
 )
 
 // Conversation represents an open channel or chat.
+// Conversations are identified by the bare JID of the contact or channel,
+// except for private conversations with an occupant of a channel which are
+// identified by the occupant's full address in the channel.
 type Conversation struct {
 	JID         jid.JID
 	Name        string
@@ -23,13 +28,46 @@ type Conversation struct {
 	firstUnread string
 	presences   []presence
 	Room        bool
+	chatState   string
+
+	// Notify controls which messages in the conversation run the notify
+	// command and MutedUntil is the time until which none of them do.
+	Notify     NotifyPolicy
+	MutedUntil time.Time
+
+	// disconnected is true if we were dropped from a group chat and have not
+	// rejoined it yet.
+	disconnected bool
+
+	// mentions is the number of unread messages that mention us.
+	mentions int
 }
 
+const (
+	// composingIndicator is shown after the name of a conversation while the
+	// other party is typing.
+	composingIndicator = " ✎"
+
+	// mutedIndicator is shown after the name of a conversation that does not
+	// trigger notifications (at the moment).
+	mutedIndicator = " 🔕"
+
+	// disconnectedIndicator is shown after the name of a group chat that we were
+	// dropped from while we try to rejoin it.
+	disconnectedIndicator = " ⚠"
+)
+
 // FirstUnread returns the ID of the first unread message.
 func (c Conversation) FirstUnread() string {
 	return c.firstUnread
 }
 
+// Muted reports whether no messages in the conversation trigger notifications
+// at the given time.
+func (c Conversation) Muted(now time.Time) bool {
+	return c.Notify == NotifyNever || now.Before(c.MutedUntil)
+}
+
 // Conversations is a tview.Primitive that draws the recent/open conversations.
 // This pane includes a mix of joined channels and recently updated 1:1 chats.
 // It does not necessarily contain all bookmarked channels or all chats from the
@@ -94,26 +132,50 @@ func (c Conversations) Upsert(item Conversation, action func(Conversation)) int
 	c.itemLock.Lock()
 	defer c.itemLock.Unlock()
 
-	bare := item.JID.Bare().String()
+	key := item.JID.String()
 	if item.Name == "" {
 		item.Name = item.JID.Localpart()
 	}
 
-	existing, ok := c.items[bare]
+	existing, ok := c.items[key]
 	if ok {
 		// Update the existing roster item.
-		c.list.SetItemText(existing.idx, item.Name, bare)
 		item.idx = existing.idx
 		item.firstUnread = existing.firstUnread
-		c.items[bare] = item
+		item.chatState = existing.chatState
+		item.Notify = existing.Notify
+		item.MutedUntil = existing.MutedUntil
+		item.disconnected = existing.disconnected
+		item.mentions = existing.mentions
+		c.list.SetItemText(existing.idx, itemText(item), key)
+		c.items[key] = item
 		return item.idx
 	}
-	c.list.AddItem(item.Name, bare, 0, func() { action(item) })
+	c.list.AddItem(itemText(item), key, 0, func() { action(item) })
 	item.idx = c.list.GetItemCount() - 1
-	c.items[bare] = item
+	c.items[key] = item
 	return item.idx
 }
 
+// itemText returns the text shown in the list for a conversation, not
+// including any unread highlighting.
+func itemText(item Conversation) string {
+	name := item.Name
+	if item.mentions > 0 {
+		name += " @" + strconv.Itoa(item.mentions)
+	}
+	if item.Muted(time.Now()) {
+		name += mutedIndicator
+	}
+	if item.disconnected {
+		name += disconnectedIndicator
+	}
+	if item.chatState == stateComposing {
+		name += composingIndicator
+	}
+	return name
+}
+
 // Draw implements tview.Primitive foc Conversations.
 func (c Conversations) Draw(screen tcell.Screen) {
 	c.flex.Draw(screen)
@@ -270,12 +332,119 @@ func (c Conversations) MarkRead(j string) {
 		return
 	}
 	item.firstUnread = ""
+	mentioned := item.mentions > 0
+	item.mentions = 0
 	c.items[j] = item
 
 	primary, secondary := c.list.GetItemText(item.idx)
+	if mentioned {
+		c.list.SetItemText(item.idx, itemText(item), secondary)
+		return
+	}
 	c.list.SetItemText(item.idx, strings.TrimPrefix(primary, highlightTag), secondary)
 }
 
+// MarkMentioned adds an unread mention to the count shown next to the given
+// conversation.
+// If the conversation does not exist, false is returned.
+func (c Conversations) MarkMentioned(j string) bool {
+	c.itemLock.Lock()
+	defer c.itemLock.Unlock()
+
+	item, ok := c.items[j]
+	if !ok {
+		return false
+	}
+	item.mentions++
+	c.items[j] = item
+	c.updateText(item)
+	return true
+}
+
+// SetChatState records the last chat state notification received from the
+// given JID and shows an indicator next to the conversation while they are
+// typing.
+// If the conversation does not exist, false is returned.
+func (c Conversations) SetChatState(j, state string) bool {
+	c.itemLock.Lock()
+	defer c.itemLock.Unlock()
+
+	item, ok := c.items[j]
+	if !ok {
+		return false
+	}
+	item.chatState = state
+	c.items[j] = item
+
+	primary, secondary := c.list.GetItemText(item.idx)
+	primary = strings.TrimSuffix(primary, composingIndicator)
+	if state == stateComposing {
+		primary += composingIndicator
+	}
+	c.list.SetItemText(item.idx, primary, secondary)
+	return true
+}
+
+// SetNotify sets the notification policy of the given conversation and the
+// time until which it is muted, and returns the updated conversation.
+// If the conversation does not exist, false is returned.
+func (c Conversations) SetNotify(j string, policy NotifyPolicy, until time.Time) (Conversation, bool) {
+	c.itemLock.Lock()
+	defer c.itemLock.Unlock()
+
+	item, ok := c.items[j]
+	if !ok {
+		return item, false
+	}
+	item.Notify = policy
+	item.MutedUntil = until
+	c.items[j] = item
+	c.updateText(item)
+	return item, true
+}
+
+// Refresh redraws the text of the given conversation, eg. once it is no longer
+// muted.
+// If the conversation does not exist, false is returned.
+func (c Conversations) Refresh(j string) bool {
+	c.itemLock.Lock()
+	defer c.itemLock.Unlock()
+
+	item, ok := c.items[j]
+	if ok {
+		c.updateText(item)
+	}
+	return ok
+}
+
+// updateText sets the text of item in the list, keeping any unread
+// highlighting.
+// The item lock must be held.
+func (c Conversations) updateText(item Conversation) {
+	primary, secondary := c.list.GetItemText(item.idx)
+	text := itemText(item)
+	if strings.HasPrefix(primary, highlightTag) {
+		text = highlightTag + tview.Escape(text)
+	}
+	c.list.SetItemText(item.idx, text, secondary)
+}
+
+// SetDisconnected sets whether we were dropped from the given group chat.
+// If the conversation does not exist, false is returned.
+func (c Conversations) SetDisconnected(j string, disconnected bool) bool {
+	c.itemLock.Lock()
+	defer c.itemLock.Unlock()
+
+	item, ok := c.items[j]
+	if !ok {
+		return false
+	}
+	item.disconnected = disconnected
+	c.items[j] = item
+	c.updateText(item)
+	return true
+}
+
 // Unread returns whether the roster item is currently marked as having unread
 // messages.
 // If no such roster item exists, it returns false.
@@ -6,9 +6,12 @@
 package event // import "mellium.im/communique/internal/ui/event"
 
 import (
+	"time"
+
 	"mellium.im/xmpp/bookmarks"
 	"mellium.im/xmpp/commands"
 	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
 	"mellium.im/xmpp/roster"
 	"mellium.im/xmpp/stanza"
 )
@@ -56,6 +59,46 @@ type (
 		stanza.Message
 
 		Body string `xml:"body,omitempty"`
+
+		// ChatState is an optional chat state (eg. "active") to send along with
+		// the message.
+		ChatState string `xml:"-"`
+
+		// Replace is the ID of an earlier message that this message corrects.
+		Replace string `xml:"-"`
+
+		// ReplyID is the ID of an earlier message that this message replies to.
+		// In group chats it is the stanza ID assigned by the group chat.
+		ReplyID string `xml:"-"`
+
+		// ReplyTo is the address of the author of the message being replied to,
+		// if it is known.
+		ReplyTo jid.JID `xml:"-"`
+	}
+
+	// RetractMessage is sent when a message that we sent earlier should be
+	// retracted.
+	RetractMessage struct {
+		To   jid.JID
+		Type stanza.MessageType
+		ID   string
+	}
+
+	// React is sent when we react to a message (XEP-0444).
+	// If we already reacted to the message with the same reaction, the reaction
+	// is removed instead.
+	React struct {
+		To       jid.JID
+		Type     stanza.MessageType
+		ID       string
+		Reaction string
+	}
+
+	// ChatState is sent when a chat state notification (eg. "composing" or
+	// "paused") should be sent.
+	ChatState struct {
+		To    jid.JID
+		State string
 	}
 
 	// OpenChat is sent when a roster item is selected.
@@ -70,6 +113,101 @@ type (
 	// Subscribe is sent when we subscribe to a users presence.
 	Subscribe jid.JID
 
+	// UpdateConversation is sent when a conversation is added to the recent
+	// conversations list or its settings change.
+	UpdateConversation struct {
+		JID        jid.JID
+		Name       string
+		Room       bool
+		Notify     string
+		MutedUntil time.Time
+	}
+
+	// DeleteConversation is sent when a conversation is removed from the recent
+	// conversations list.
+	DeleteConversation jid.JID
+
+	// Search is sent when the user searches the message history.
+	// If JID is the zero value, the history of all conversations is searched.
+	Search struct {
+		Query string
+		JID   jid.JID
+	}
+
+	// JumpToMessage is sent when a conversation should be opened with one of its
+	// messages selected (eg. after picking a search result).
+	// The ID is the stanza ID assigned by the group chat for group chat
+	// messages.
+	JumpToMessage struct {
+		roster.Item
+		ID string
+	}
+
+	// PauseSync is sent when syncing the history of all conversations should be
+	// paused.
+	PauseSync struct{}
+
+	// ResumeSync is sent when a paused history sync should continue.
+	ResumeSync struct{}
+
+	// CancelSync is sent when syncing the history of all conversations should be
+	// stopped.
+	CancelSync struct{}
+
+	// SetRole is sent when the role of an occupant of a group chat should be
+	// changed (eg. to kick them or grant them voice).
+	SetRole struct {
+		Room   jid.JID
+		Nick   string
+		Role   muc.Role
+		Reason string
+	}
+
+	// SetAffiliation is sent when the affiliation of a user to a group chat
+	// should be changed (eg. to ban them or make them a member).
+	SetAffiliation struct {
+		Room        jid.JID
+		JID         jid.JID
+		Affiliation muc.Affiliation
+		Reason      string
+	}
+
+	// Invite is sent when someone should be invited to a group chat.
+	Invite struct {
+		Room   jid.JID
+		To     jid.JID
+		Reason string
+	}
+
+	// DeclineInvite is sent when an invitation to a group chat that was sent
+	// through the group chat is declined.
+	DeclineInvite struct {
+		Room jid.JID
+		From jid.JID
+	}
+
+	// ChangeNick is sent when our nickname in a group chat should be changed.
+	ChangeNick struct {
+		Room jid.JID
+		Nick string
+	}
+
+	// CreateChannel is sent when a new group chat should be created.
+	CreateChannel jid.JID
+
+	// ConfigureChannel is sent when the configuration form of a group chat
+	// should be shown.
+	// Created is true if the group chat was just created and is waiting to be
+	// configured before it can be used.
+	ConfigureChannel struct {
+		JID     jid.JID
+		Created bool
+	}
+
+	// AcceptChannelDefaults is sent when a group chat that we just created should
+	// use the default configuration.
+	AcceptChannelDefaults jid.JID
+
 	// PullToRefreshChat is sent when we scroll up while already at the top of
 	// the history or when we simply scroll to the top of the history.
 	PullToRefreshChat roster.Item
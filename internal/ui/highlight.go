@@ -0,0 +1,103 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package ui
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rivo/tview"
+
+	"mellium.im/xmpp/jid"
+)
+
+// WordRule returns a highlight rule that matches word, ignoring case, wherever
+// it appears as a whole word.
+func WordRule(word string) *regexp.Regexp {
+	expr := "(?i)" + regexp.QuoteMeta(word)
+	// \b only matches next to word characters, so nicknames that start or end
+	// with punctuation are matched anywhere.
+	if r, _ := utf8.DecodeRuneInString(word); isWordRune(r) {
+		expr = `\b` + expr
+	}
+	if r, _ := utf8.DecodeLastRuneInString(word); isWordRune(r) {
+		expr += `\b`
+	}
+	return regexp.MustCompile(expr)
+}
+
+// isWordRune reports whether r is matched by \w (which, like \b, only
+// considers ASCII).
+func isWordRune(r rune) bool {
+	return r < utf8.RuneSelf && (r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+// Highlights returns an option that sets the rules matched against messages
+// received in group chats in addition to our own nickname.
+// Matches are colored and count as mentions.
+func Highlights(rules []*regexp.Regexp) Option {
+	return func(ui *UI) {
+		ui.highlights = rules
+	}
+}
+
+// highlightRules returns the rules that count as a mention in room.
+func (ui *UI) highlightRules(room jid.JID) []*regexp.Regexp {
+	rules := ui.highlights
+	if me, ok := ui.history.occupants.self(room); ok && me.JID.Resourcepart() != "" {
+		rules = append(slices.Clip(rules), WordRule(me.JID.Resourcepart()))
+	}
+	return rules
+}
+
+// Mentions reports whether body, received in the group chat room, mentions our
+// nickname or matches one of the highlight rules.
+func (ui *UI) Mentions(room jid.JID, body string) bool {
+	for _, rule := range ui.highlightRules(room) {
+		if rule.MatchString(body) {
+			return true
+		}
+	}
+	return false
+}
+
+// Highlight returns text, received in the group chat room, escaped and with any
+// mentions of our nickname or matches of the highlight rules colored.
+// Text should not already be escaped so that the rules are matched against what
+// was actually sent.
+func (ui *UI) Highlight(room jid.JID, text string) string {
+	var matches [][]int
+	for _, rule := range ui.highlightRules(room) {
+		matches = append(matches, rule.FindAllStringIndex(text, -1)...)
+	}
+	if len(matches) == 0 {
+		return tview.Escape(text)
+	}
+	slices.SortFunc(matches, func(a, b []int) int {
+		return a[0] - b[0]
+	})
+
+	tag := fmt.Sprintf("[#%06x]", tview.Styles.SecondaryTextColor.Hex())
+	var buf strings.Builder
+	var last int
+	for _, m := range matches {
+		// Skip matches that overlap one that was already highlighted.
+		start := max(m[0], last)
+		if start >= m[1] {
+			continue
+		}
+		buf.WriteString(tview.Escape(text[last:start]))
+		buf.WriteString(tag)
+		buf.WriteString(tview.Escape(text[start:m[1]]))
+		buf.WriteString("[-]")
+		last = m[1]
+	}
+	buf.WriteString(tview.Escape(text[last:]))
+	return buf.String()
+}
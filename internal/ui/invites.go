@@ -0,0 +1,131 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package ui
+
+import (
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+
+	"mellium.im/communique/internal/ui/event"
+	"mellium.im/xmpp/bookmarks"
+	"mellium.im/xmpp/jid"
+)
+
+// Invitation is an invitation to join a group chat.
+type Invitation struct {
+	Room     jid.JID
+	From     jid.JID
+	Reason   string
+	Password string
+	// Direct is true if the invitation was sent by the person inviting us
+	// instead of through the group chat, in which case declining it doesn't tell
+	// them.
+	Direct bool
+}
+
+// ShowInvitation asks whether to join a group chat that we have been invited
+// to.
+func (ui *UI) ShowInvitation(inv Invitation) {
+	pageName := "invite_" + inv.Room.String()
+	p := ui.Printer()
+	var (
+		joinButton     = p.Sprintf("Join")
+		bookmarkButton = p.Sprintf("Join and Bookmark")
+		declineButton  = p.Sprintf("Decline")
+	)
+	text := p.Sprintf("%s invited you to join %s.", inv.From, inv.Room)
+	if inv.Reason != "" {
+		text += "\n\n" + p.Sprintf("Reason: %s", inv.Reason)
+	}
+	if inv.Password != "" {
+		text += "\n\n" + p.Sprintf("Password: %s", inv.Password)
+	}
+	onEsc := func() {
+		ui.pages.HidePage(pageName)
+		ui.pages.RemovePage(pageName)
+	}
+	mod := NewModal().
+		SetText(text).
+		AddButtons([]string{joinButton, bookmarkButton, declineButton}).
+		SetDoneFunc(func(_ int, buttonLabel string) {
+			onEsc()
+			channel := bookmarks.Channel{
+				JID:      inv.Room,
+				Password: inv.Password,
+			}
+			switch buttonLabel {
+			case bookmarkButton:
+				ui.UpdateBookmarks(channel)
+				ui.JoinChannel(channel)
+			case joinButton:
+				ui.JoinChannel(channel)
+			case declineButton:
+				if !inv.Direct {
+					ui.handler(event.DeclineInvite{Room: inv.Room, From: inv.From})
+				}
+			}
+		})
+	mod.SetInputCapture(modalClose(onEsc))
+	ui.pages.AddPage(pageName, mod, true, false)
+	ui.pages.ShowPage(pageName)
+	ui.pages.SendToFront(pageName)
+	ui.app.SetFocus(ui.pages)
+	ui.redraw()
+}
+
+// ShowInvite asks for a contact to invite to a group chat.
+func (ui *UI) ShowInvite(room jid.JID) {
+	const pageName = "invite"
+	p := ui.Printer()
+	var (
+		cancelButton = p.Sprintf("Cancel")
+		inviteButton = p.Sprintf("Invite")
+	)
+	autocomplete := make([]jid.JID, 0, len(ui.sidebar.roster.items))
+	for _, item := range ui.sidebar.roster.items {
+		autocomplete = append(autocomplete, item.JID)
+	}
+	var (
+		to     jid.JID
+		reason string
+	)
+	onEsc := func() {
+		ui.pages.HidePage(pageName)
+		ui.pages.RemovePage(pageName)
+	}
+	mod := NewModal().
+		SetText(p.Sprintf("Invite to %s", room))
+	modForm := mod.Form()
+	modForm.AddFormItem(jidInput(p, &to, true, autocomplete, nil))
+	modForm.AddFormItem(tview.NewInputField().
+		SetLabel(p.Sprintf("Reason")).
+		SetChangedFunc(func(text string) {
+			reason = text
+		}))
+	mod.AddButtons([]string{cancelButton, inviteButton}).
+		SetDoneFunc(func(_ int, buttonLabel string) {
+			if buttonLabel == inviteButton {
+				if to.Equal(jid.JID{}) {
+					return
+				}
+				ui.handler(event.Invite{Room: room.Bare(), To: to, Reason: strings.TrimSpace(reason)})
+			}
+			onEsc()
+		})
+	// Don't use modalClose because we don't want typing a "q" in the address or
+	// reason fields to close the modal.
+	mod.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyESC {
+			onEsc()
+		}
+		return event
+	})
+	ui.pages.AddPage(pageName, mod, true, false)
+	ui.pages.ShowPage(pageName)
+	ui.pages.SendToFront(pageName)
+	ui.app.SetFocus(ui.pages)
+}
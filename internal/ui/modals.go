@@ -144,3 +144,66 @@ func jidInput(p *message.Printer, inputJID *jid.JID, bare bool, autocomplete []j
 	})
 	return jidInput
 }
+
+// reactions is the list of reactions that can be picked from the reaction
+// modal.
+var reactions = []string{"👍", "❤️", "😂", "😮", "😢", "🙏"}
+
+func reactionsModal(p *message.Printer, onEsc func(), onReact func(string)) *Modal {
+	mod := NewModal().
+		SetText(p.Sprintf("React to this message (pick a reaction again to remove it)")).
+		AddButtons(reactions).
+		SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+			if buttonIndex >= 0 {
+				onReact(buttonLabel)
+			}
+			onEsc()
+		})
+	mod.SetInputCapture(modalClose(onEsc))
+	return mod
+}
+
+// searchModal asks for words to search the history for and whether to search
+// all conversations or just the open one.
+func searchModal(p *message.Printer, onEsc func(), onSearch func(query string, all bool)) *Modal {
+	var (
+		cancelButton = p.Sprintf("Cancel")
+		searchButton = p.Sprintf("Search")
+	)
+	var query string
+	var all bool
+	mod := NewModal().
+		SetText(p.Sprintf("Search Messages"))
+	input := tview.NewInputField().
+		SetLabel(p.Sprintf("Words")).
+		SetChangedFunc(func(text string) {
+			query = text
+		})
+	input.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEnter && strings.TrimSpace(query) != "" {
+			onSearch(query, all)
+		}
+	})
+	modForm := mod.Form()
+	modForm.AddFormItem(input)
+	modForm.AddCheckbox(p.Sprintf("All conversations"), false, func(checked bool) {
+		all = checked
+	})
+	mod.AddButtons([]string{cancelButton, searchButton}).
+		SetDoneFunc(func(_ int, buttonLabel string) {
+			if buttonLabel == searchButton && strings.TrimSpace(query) != "" {
+				onSearch(query, all)
+				return
+			}
+			onEsc()
+		})
+	// Don't use modalClose because we don't want typing a "q" in the search
+	// field to close the modal.
+	mod.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyESC {
+			onEsc()
+		}
+		return event
+	})
+	return mod
+}
@@ -0,0 +1,248 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package ui
+
+import (
+	"encoding/xml"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+
+	"mellium.im/communique/internal/ui/event"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+)
+
+const moderatePageName = "moderate"
+
+// roles and affiliations are the options that can be picked when changing the
+// role or affiliation of an occupant.
+var (
+	roles        = []muc.Role{muc.RoleVisitor, muc.RoleParticipant, muc.RoleModerator}
+	affiliations = []muc.Affiliation{muc.AffiliationNone, muc.AffiliationMember, muc.AffiliationAdmin, muc.AffiliationOwner, muc.AffiliationOutcast}
+)
+
+// showModerate shows a modal asking to confirm a moderation action.
+// If options is not empty a drop down is shown to pick one of them, and a
+// reason can optionally be given which is passed to onConfirm along with the
+// index of the option that was picked.
+func (ui *UI) showModerate(text, confirmButton string, options []string, selected int, reason string, onConfirm func(option int, reason string)) {
+	p := ui.Printer()
+	cancelButton := p.Sprintf("Cancel")
+	onEsc := func() {
+		ui.pages.HidePage(moderatePageName)
+		ui.pages.RemovePage(moderatePageName)
+	}
+	mod := NewModal().
+		SetText(text)
+	modForm := mod.Form()
+	if len(options) > 0 {
+		modForm.AddDropDown("", options, selected, func(_ string, idx int) {
+			selected = idx
+		})
+	}
+	modForm.AddInputField(p.Sprintf("Reason"), reason, 0, nil, func(text string) {
+		reason = text
+	})
+	mod.AddButtons([]string{cancelButton, confirmButton}).
+		SetDoneFunc(func(_ int, buttonLabel string) {
+			onEsc()
+			if buttonLabel == confirmButton {
+				onConfirm(selected, strings.TrimSpace(reason))
+			}
+		})
+	// Don't use modalClose because we don't want typing a "q" in the reason field
+	// to close the modal.
+	mod.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyESC {
+			onEsc()
+		}
+		return event
+	})
+	ui.pages.AddPage(moderatePageName, mod, true, false)
+	ui.pages.ShowPage(moderatePageName)
+	ui.pages.SendToFront(moderatePageName)
+	ui.app.SetFocus(ui.pages)
+}
+
+// ShowModerationError shows an error returned by a group chat when a
+// moderation action was not allowed.
+func (ui *UI) ShowModerationError(err error) {
+	p := ui.Printer()
+	ui.ShowError(p.Sprintf("Moderation failed: %v", err))
+}
+
+// kick asks to confirm kicking an occupant from their group chat.
+func (ui *UI) kick(o Occupant, reason string) {
+	p := ui.Printer()
+	nick := o.JID.Resourcepart()
+	ui.showModerate(p.Sprintf("Kick %s from the group chat?", tview.Escape(nick)), p.Sprintf("Kick"), nil, 0, reason, func(_ int, reason string) {
+		ui.handler(event.SetRole{Room: o.JID.Bare(), Nick: nick, Role: muc.RoleNone, Reason: reason})
+	})
+}
+
+// ban asks to confirm banning an occupant from their group chat.
+// Bans apply to the real JID of the occupant, so only occupants whose real JID
+// is visible can be banned.
+func (ui *UI) ban(o Occupant, reason string) {
+	p := ui.Printer()
+	nick := o.JID.Resourcepart()
+	if o.RealJID.Equal(jid.JID{}) {
+		ui.ShowError(p.Sprintf("The address of %s is not visible to you.", nick))
+		return
+	}
+	ui.showModerate(p.Sprintf("Ban %s (%s) from the group chat?", tview.Escape(nick), o.RealJID.Bare()), p.Sprintf("Ban"), nil, 0, reason, func(_ int, reason string) {
+		ui.handler(event.SetAffiliation{Room: o.JID.Bare(), JID: o.RealJID.Bare(), Affiliation: muc.AffiliationOutcast, Reason: reason})
+	})
+}
+
+// setVoice asks to confirm granting or revoking voice in a group chat.
+func (ui *UI) setVoice(o Occupant, voice bool, reason string) {
+	p := ui.Printer()
+	nick := o.JID.Resourcepart()
+	text, button, role := p.Sprintf("Revoke voice from %s?", tview.Escape(nick)), p.Sprintf("Revoke"), muc.RoleVisitor
+	if voice {
+		text, button, role = p.Sprintf("Grant voice to %s?", tview.Escape(nick)), p.Sprintf("Grant"), muc.RoleParticipant
+	}
+	ui.showModerate(text, button, nil, 0, reason, func(_ int, reason string) {
+		ui.handler(event.SetRole{Room: o.JID.Bare(), Nick: nick, Role: role, Reason: reason})
+	})
+}
+
+// pickRole asks for a new role for an occupant of a group chat.
+func (ui *UI) pickRole(o Occupant, role muc.Role, reason string) {
+	p := ui.Printer()
+	nick := o.JID.Resourcepart()
+	opts := make([]string, 0, len(roles))
+	selected := 0
+	for i, r := range roles {
+		opts = append(opts, r.String())
+		if r == role {
+			selected = i
+		}
+	}
+	ui.showModerate(p.Sprintf("Change the role of %s", tview.Escape(nick)), p.Sprintf("Change"), opts, selected, reason, func(idx int, reason string) {
+		ui.handler(event.SetRole{Room: o.JID.Bare(), Nick: nick, Role: roles[idx], Reason: reason})
+	})
+}
+
+// pickAffiliation asks for a new affiliation for a user (identified by their
+// real JID) to a group chat.
+func (ui *UI) pickAffiliation(room, j jid.JID, affiliation muc.Affiliation, reason string) {
+	p := ui.Printer()
+	if j.Equal(jid.JID{}) {
+		ui.ShowError(p.Sprintf("The address of the occupant is not visible to you."))
+		return
+	}
+	opts := make([]string, 0, len(affiliations))
+	selected := 0
+	for i, a := range affiliations {
+		opts = append(opts, a.String())
+		if a == affiliation {
+			selected = i
+		}
+	}
+	ui.showModerate(p.Sprintf("Change the affiliation of %s", j.Bare()), p.Sprintf("Change"), opts, selected, reason, func(idx int, reason string) {
+		ui.handler(event.SetAffiliation{Room: room.Bare(), JID: j.Bare(), Affiliation: affiliations[idx], Reason: reason})
+	})
+}
+
+// moderateKey handles the moderation keys in the occupant list and reports
+// whether the key was one of them.
+func (ui *UI) moderateKey(o Occupant, ev *tcell.EventKey) bool {
+	if ev.Key() != tcell.KeyRune {
+		return false
+	}
+	switch ev.Rune() {
+	case 'K':
+		ui.kick(o, "")
+	case 'B':
+		ui.ban(o, "")
+	case 'v':
+		ui.setVoice(o, o.Role == muc.RoleVisitor, "")
+	case 'r':
+		ui.pickRole(o, o.Role, "")
+	case 'a':
+		ui.pickAffiliation(o.JID.Bare(), o.RealJID, o.Affiliation, "")
+	default:
+		return false
+	}
+	return true
+}
+
+// channelCommand handles commands typed into the message input of a group chat
+// (eg. "/kick nick reason") and reports whether body was one.
+func (ui *UI) channelCommand(room jid.JID, body string) bool {
+	p := ui.Printer()
+	cmd, args, _ := strings.Cut(body, " ")
+	args = strings.TrimSpace(args)
+	switch cmd {
+	case "/invite":
+		addr, reason, _ := strings.Cut(args, " ")
+		to, err := jid.Parse(addr)
+		if err != nil {
+			ui.ShowError(p.Sprintf("Usage: /invite address [reason]"))
+			return true
+		}
+		ui.handler(event.Invite{Room: room.Bare(), To: to, Reason: strings.TrimSpace(reason)})
+	case "/nick":
+		if args == "" {
+			ui.ShowError(p.Sprintf("Usage: /nick nickname"))
+			return true
+		}
+		ui.handler(event.ChangeNick{Room: room.Bare(), Nick: args})
+	case "/kick", "/ban", "/voice", "/devoice":
+		o, reason, ok := ui.history.occupants.find(room, args)
+		if !ok {
+			ui.ShowError(p.Sprintf("There is no occupant named %q.", args))
+			return true
+		}
+		switch cmd {
+		case "/kick":
+			ui.kick(o, reason)
+		case "/ban":
+			ui.ban(o, reason)
+		default:
+			ui.setVoice(o, cmd == "/voice", reason)
+		}
+	case "/role":
+		idx := strings.LastIndexByte(args, ' ')
+		var role muc.Role
+		if idx < 0 || role.UnmarshalXMLAttr(xml.Attr{Value: args[idx+1:]}) != nil {
+			ui.ShowError(p.Sprintf("Usage: /role nick visitor|participant|moderator"))
+			return true
+		}
+		o, _, ok := ui.history.occupants.find(room, args[:idx])
+		if !ok || o.JID.Resourcepart() != args[:idx] {
+			ui.ShowError(p.Sprintf("There is no occupant named %q.", args[:idx]))
+			return true
+		}
+		ui.pickRole(o, role, "")
+	case "/affiliation":
+		idx := strings.LastIndexByte(args, ' ')
+		var affiliation muc.Affiliation
+		if idx < 0 || affiliation.UnmarshalXMLAttr(xml.Attr{Value: args[idx+1:]}) != nil {
+			ui.ShowError(p.Sprintf("Usage: /affiliation nick|address none|member|admin|owner|outcast"))
+			return true
+		}
+		// The user can be an occupant or the address of someone who isn't in the
+		// group chat (eg. to ban them before they join).
+		target := args[:idx]
+		var j jid.JID
+		if o, _, ok := ui.history.occupants.find(room, target); ok && o.JID.Resourcepart() == target {
+			j = o.RealJID
+		} else if parsed, err := jid.Parse(target); err == nil {
+			j = parsed
+		} else {
+			ui.ShowError(p.Sprintf("There is no occupant named %q.", target))
+			return true
+		}
+		ui.pickAffiliation(room, j, affiliation, "")
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,157 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package ui
+
+import (
+	"time"
+
+	"mellium.im/communique/internal/ui/event"
+	"mellium.im/xmpp/jid"
+)
+
+// NotifyPolicy controls which messages received in a conversation run the
+// notify command.
+type NotifyPolicy string
+
+// A list of notification policies.
+const (
+	// NotifyDefault notifies of every message in one-to-one chats and of
+	// messages that mention us in group chats.
+	NotifyDefault  NotifyPolicy = ""
+	NotifyAlways   NotifyPolicy = "always"
+	NotifyMentions NotifyPolicy = "mentions"
+	NotifyNever    NotifyPolicy = "never"
+)
+
+// muteFor is how long a conversation is muted for when "mute for an hour" is
+// picked.
+const muteFor = time.Hour
+
+// ShouldNotify reports whether a message received in the conversation j should
+// run the notify command.
+// Mentioned is true if the message mentions us or matches one of the highlight
+// rules.
+func (ui *UI) ShouldNotify(j jid.JID, groupChat, mentioned bool) bool {
+	policy := NotifyDefault
+	if c, ok := ui.sidebar.conversations.GetItem(j.String()); ok {
+		if time.Now().Before(c.MutedUntil) {
+			return false
+		}
+		policy = c.Notify
+	}
+	if policy == NotifyDefault {
+		policy = NotifyAlways
+		if groupChat {
+			policy = NotifyMentions
+		}
+	}
+	switch policy {
+	case NotifyNever:
+		return false
+	case NotifyMentions:
+		return mentioned
+	}
+	return true
+}
+
+// SetNotify sets the notification policy of the given conversation and mutes it
+// until the given time (or unmutes it if until is the zero time).
+func (ui *UI) SetNotify(j string, policy NotifyPolicy, until time.Time) {
+	c, ok := ui.sidebar.conversations.SetNotify(j, policy, until)
+	if !ok {
+		return
+	}
+	ui.handler(event.UpdateConversation{
+		JID:        c.JID,
+		Name:       c.Name,
+		Room:       c.Room,
+		Notify:     string(c.Notify),
+		MutedUntil: c.MutedUntil,
+	})
+	ui.unmuteLater(c)
+	ui.redraw()
+}
+
+// unmuteLater redraws the conversation once it is no longer muted so that it
+// stops being shown as muted.
+func (ui *UI) unmuteLater(c Conversation) {
+	d := time.Until(c.MutedUntil)
+	if d <= 0 {
+		return
+	}
+	time.AfterFunc(d, func() {
+		if ui.sidebar.conversations.Refresh(c.JID.String()) {
+			ui.redraw()
+		}
+	})
+}
+
+// ShowNotifySettings shows the notification settings of a conversation and
+// lets the user change them.
+func (ui *UI) ShowNotifySettings(c Conversation) {
+	const pageName = "notify_settings"
+	p := ui.Printer()
+	var (
+		defaultButton  = p.Sprintf("Default")
+		alwaysButton   = p.Sprintf("Always")
+		mentionsButton = p.Sprintf("Mentions")
+		neverButton    = p.Sprintf("Never")
+		hourButton     = p.Sprintf("Mute 1h")
+		tomorrowButton = p.Sprintf("Mute Until Tomorrow")
+	)
+
+	var current string
+	switch c.Notify {
+	case NotifyAlways:
+		current = p.Sprintf("all messages")
+	case NotifyMentions:
+		current = p.Sprintf("mentions only")
+	case NotifyNever:
+		current = p.Sprintf("never")
+	default:
+		current = p.Sprintf("all messages")
+		if c.Room {
+			current = p.Sprintf("mentions only")
+		}
+		current = p.Sprintf("default (%s)", current)
+	}
+	text := p.Sprintf("Notify for %s: %s", c.Name, current)
+	if until := c.MutedUntil; time.Now().Before(until) {
+		text += "\n" + p.Sprintf("Muted until %s", until.Local().Format("Jan 2 "+ui.timeFormat))
+	}
+
+	onEsc := func() {
+		ui.pages.HidePage(pageName)
+		ui.pages.RemovePage(pageName)
+	}
+	mod := NewModal().
+		SetText(text).
+		AddButtons([]string{defaultButton, alwaysButton, mentionsButton, neverButton, hourButton, tomorrowButton}).
+		SetDoneFunc(func(_ int, buttonLabel string) {
+			onEsc()
+			j := c.JID.String()
+			now := time.Now()
+			switch buttonLabel {
+			case defaultButton:
+				ui.SetNotify(j, NotifyDefault, time.Time{})
+			case alwaysButton:
+				ui.SetNotify(j, NotifyAlways, time.Time{})
+			case mentionsButton:
+				ui.SetNotify(j, NotifyMentions, time.Time{})
+			case neverButton:
+				ui.SetNotify(j, NotifyNever, time.Time{})
+			case hourButton:
+				ui.SetNotify(j, c.Notify, now.Add(muteFor))
+			case tomorrowButton:
+				tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.Local)
+				ui.SetNotify(j, c.Notify, tomorrow)
+			}
+		})
+	mod.SetInputCapture(modalClose(onEsc))
+	ui.pages.AddPage(pageName, mod, true, false)
+	ui.pages.ShowPage(pageName)
+	ui.pages.SendToFront(pageName)
+	ui.app.SetFocus(ui.pages)
+}
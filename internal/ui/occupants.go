@@ -0,0 +1,315 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package ui
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/rivo/tview"
+	"golang.org/x/text/message"
+
+	"mellium.im/communique/internal/ui/event"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+	"mellium.im/xmpp/roster"
+)
+
+// occupantsWidth is the width of the occupant list when it is shown.
+const occupantsWidth = 24
+
+// Occupant is a participant in a group chat.
+type Occupant struct {
+	// JID is the address of the occupant in the group chat (the address of the
+	// chat with their nickname as the resourcepart).
+	JID jid.JID
+	// RealJID is the occupant's own address if the group chat shows it to us.
+	RealJID     jid.JID
+	Affiliation muc.Affiliation
+	Role        muc.Role
+	// Show is the availability of the occupant (eg. "away") or empty if they are
+	// online.
+	Show string
+	// Self is true if the occupant is us.
+	Self bool
+}
+
+// status returns the presence status of the occupant as used by the roster.
+func (o Occupant) status() string {
+	switch o.Show {
+	case "away", "xa":
+		return statusAway
+	case "dnd":
+		return statusBusy
+	}
+	return statusOnline
+}
+
+// occupantList shows the occupants of the open group chat grouped by role.
+type occupantList struct {
+	*tview.List
+	m       sync.Mutex
+	p       *message.Printer
+	visible bool
+	room    string
+	rooms   map[string]map[string]Occupant
+	shown   []Occupant
+}
+
+func newOccupantList(p *message.Printer) *occupantList {
+	l := &occupantList{
+		List:  tview.NewList(),
+		p:     p,
+		rooms: make(map[string]map[string]Occupant),
+	}
+	l.List.ShowSecondaryText(false).
+		SetHighlightFullLine(true).
+		SetBorder(true).
+		SetTitle(p.Sprintf("Occupants"))
+	return l
+}
+
+// upsert adds or updates an occupant and reports whether the list that is
+// shown changed.
+func (l *occupantList) upsert(o Occupant) bool {
+	l.m.Lock()
+	defer l.m.Unlock()
+	room := o.JID.Bare().String()
+	occupants, ok := l.rooms[room]
+	if !ok {
+		occupants = make(map[string]Occupant)
+		l.rooms[room] = occupants
+	}
+	occupants[o.JID.Resourcepart()] = o
+	return l.rebuildLocked(room)
+}
+
+// remove removes an occupant and reports whether the list that is shown
+// changed.
+func (l *occupantList) remove(j jid.JID) bool {
+	l.m.Lock()
+	defer l.m.Unlock()
+	room := j.Bare().String()
+	delete(l.rooms[room], j.Resourcepart())
+	return l.rebuildLocked(room)
+}
+
+// clear removes all occupants of a group chat, or of every group chat if room
+// is the zero value.
+func (l *occupantList) clear(room jid.JID) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if room.Equal(jid.JID{}) {
+		clear(l.rooms)
+		l.rebuildLocked(l.room)
+		return
+	}
+	delete(l.rooms, room.Bare().String())
+	l.rebuildLocked(room.Bare().String())
+}
+
+// show switches the list to the occupants of the given group chat.
+func (l *occupantList) show(room jid.JID) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if s := room.Bare().String(); s != l.room {
+		l.room = s
+		l.rebuildLocked(s)
+	}
+}
+
+// selected returns the occupant that is currently selected, if any.
+func (l *occupantList) selected() (Occupant, bool) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	idx := l.List.GetCurrentItem()
+	if idx < 0 || idx >= len(l.shown) || l.shown[idx].JID.Equal(jid.JID{}) {
+		return Occupant{}, false
+	}
+	return l.shown[idx], true
+}
+
+// self returns our own occupant in room, if we are in it.
+func (l *occupantList) self(room jid.JID) (Occupant, bool) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	for _, o := range l.rooms[room.Bare().String()] {
+		if o.Self {
+			return o, true
+		}
+	}
+	return Occupant{}, false
+}
+
+// find looks up the occupant of room whose nickname text starts with and
+// returns them along with the rest of the text (eg. the reason given in a
+// command).
+// If more than one nickname matches, the longest one is used.
+func (l *occupantList) find(room jid.JID, text string) (Occupant, string, bool) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	var (
+		found Occupant
+		rest  string
+		ok    bool
+	)
+	for nick, o := range l.rooms[room.Bare().String()] {
+		if ok && len(nick) <= len(found.JID.Resourcepart()) {
+			continue
+		}
+		if text == nick {
+			found, rest, ok = o, "", true
+		} else if after, match := strings.CutPrefix(text, nick+" "); match {
+			found, rest, ok = o, strings.TrimSpace(after), true
+		}
+	}
+	return found, rest, ok
+}
+
+// rebuildLocked redraws the list if it is showing room and reports whether it
+// did.
+func (l *occupantList) rebuildLocked(room string) bool {
+	if room != l.room {
+		return false
+	}
+	groups := []struct {
+		role  muc.Role
+		title string
+	}{
+		{role: muc.RoleModerator, title: l.p.Sprintf("Moderators")},
+		{role: muc.RoleParticipant, title: l.p.Sprintf("Participants")},
+		{role: muc.RoleVisitor, title: l.p.Sprintf("Visitors")},
+	}
+	cur := l.List.GetCurrentItem()
+	l.List.Clear()
+	l.shown = l.shown[:0]
+	for _, group := range groups {
+		var nicks []string
+		for nick, o := range l.rooms[room] {
+			if o.Role == group.role {
+				nicks = append(nicks, nick)
+			}
+		}
+		if len(nicks) == 0 {
+			continue
+		}
+		slices.SortFunc(nicks, func(a, b string) int {
+			return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+		})
+		// Group headers are shown in the list but can't be picked.
+		l.List.AddItem(fmt.Sprintf("[::b]%s (%d)[::-]", group.title, len(nicks)), "", 0, nil)
+		l.shown = append(l.shown, Occupant{})
+		for _, nick := range nicks {
+			o := l.rooms[room][nick]
+			l.List.AddItem(statusIcon(o.status())+" "+tview.Escape(nick), "", 0, nil)
+			l.shown = append(l.shown, o)
+		}
+	}
+	if cur > 0 && cur < l.List.GetItemCount() {
+		l.List.SetCurrentItem(cur)
+	}
+	return true
+}
+
+// UpdateOccupant adds an occupant to the list of occupants of their group chat
+// or updates their role, affiliation, and status.
+func (ui *UI) UpdateOccupant(o Occupant) {
+	if ui.history.occupants.upsert(o) {
+		ui.redraw()
+	}
+}
+
+// RemoveOccupant removes an occupant that has left their group chat.
+func (ui *UI) RemoveOccupant(j jid.JID) {
+	if ui.history.occupants.remove(j) {
+		ui.redraw()
+	}
+}
+
+// ClearOccupants removes all occupants of a group chat that we have left, or of
+// all group chats if room is the zero value.
+func (ui *UI) ClearOccupants(room jid.JID) {
+	ui.history.occupants.clear(room)
+	ui.redraw()
+}
+
+// ShowOccupantInfo displays more info about an occupant of a group chat.
+func (ui *UI) ShowOccupantInfo(o Occupant) {
+	p := ui.Printer()
+	var infoTmpl = template.Must(template.New("info").Funcs(template.FuncMap{
+		"printf": p.Sprintf,
+	}).Parse(`
+🛈
+
+{{ .JID.Resourcepart }}
+{{ .RealJID }}
+
+{{ printf "Role" }}: {{ .Role }}
+{{ printf "Affiliation" }}: {{ .Affiliation }}
+{{ printf "Status" }}: {{ .Status }}
+`))
+
+	onEsc := func() {
+		ui.pages.HidePage(infoPageName)
+		ui.pages.RemovePage(infoPageName)
+	}
+	mod := NewModal().
+		SetDoneFunc(func(int, string) {
+			onEsc()
+		})
+	mod.SetInputCapture(modalClose(onEsc))
+
+	var buf strings.Builder
+	err := infoTmpl.Execute(&buf, struct {
+		Occupant
+		Status string
+	}{
+		Occupant: o,
+		Status:   statusIcon(o.status()),
+	})
+	if err != nil {
+		ui.debug.Print(p.Sprintf("error executing info template: %v", err))
+		return
+	}
+	mod.SetText(buf.String())
+	ui.pages.AddPage(infoPageName, mod, true, false)
+	ui.pages.ShowPage(infoPageName)
+	ui.pages.SendToFront(infoPageName)
+	ui.app.SetFocus(ui.pages)
+}
+
+// PrivateName returns the name shown for a private conversation with an
+// occupant of a group chat.
+func PrivateName(j jid.JID) string {
+	return j.Resourcepart() + " (" + j.Localpart() + ")"
+}
+
+// OpenPrivateChat opens a private conversation with an occupant of a group
+// chat.
+// Private conversations are kept separate from the group chat and are
+// addressed to the occupant's nickname in the group chat.
+func (ui *UI) OpenPrivateChat(o Occupant) {
+	selected := func(c Conversation) {
+		ui.buffers.SwitchToPage(chatPageName)
+		ui.chatsOpen.Set(true)
+		ui.handler(event.OpenChat(roster.Item{
+			JID:  c.JID,
+			Name: c.Name,
+		}))
+		ui.app.SetFocus(ui.buffers)
+	}
+	c := Conversation{
+		JID:  o.JID,
+		Name: PrivateName(o.JID),
+	}
+	idx := ui.upsertConversation(c, selected)
+	ui.sidebar.conversations.list.SetCurrentItem(idx)
+	ui.sidebar.dropDown.SetCurrentOption(0)
+	selected(c)
+	ui.redraw()
+}
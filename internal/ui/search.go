@@ -0,0 +1,126 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package ui
+
+import (
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+
+	"mellium.im/communique/internal/ui/event"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/roster"
+)
+
+// SearchResult is a message that matched a search of the history.
+type SearchResult struct {
+	// JID is the address of the conversation that the message belongs to.
+	JID  jid.JID
+	Room bool
+	// ID is the ID of the message, or in group chats the stanza ID assigned by
+	// the group chat.
+	ID string
+	// Label and Snippet are shown in the list of results and may contain style
+	// tags.
+	Label   string
+	Snippet string
+}
+
+func newSearchResults(ui *UI) *tview.List {
+	results := tview.NewList()
+	results.SetBorder(true)
+	results.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() != tcell.KeyESC {
+			return event
+		}
+		if ui.ChatsOpen() {
+			ui.buffers.SwitchToPage(chatPageName)
+			ui.app.SetFocus(ui.buffers)
+			return nil
+		}
+		ui.SelectRoster()
+		return nil
+	})
+	return results
+}
+
+// ShowSearch asks the user what to search for in the history of the
+// conversation with j (or all conversations).
+func (ui *UI) ShowSearch(j jid.JID) {
+	const pageName = "search_prompt"
+	onEsc := func() {
+		ui.pages.HidePage(pageName)
+		ui.pages.RemovePage(pageName)
+		ui.app.SetFocus(ui.buffers)
+	}
+	mod := searchModal(ui.Printer(), onEsc, func(query string, all bool) {
+		onEsc()
+		ev := event.Search{Query: query}
+		if !all {
+			ev.JID = j
+		}
+		ui.handler(ev)
+	})
+	ui.pages.AddPage(pageName, mod, true, false)
+	ui.pages.ShowPage(pageName)
+	ui.pages.SendToFront(pageName)
+	ui.app.SetFocus(ui.pages)
+}
+
+// ShowSearchResults lists messages that matched a search.
+// Picking one of them opens the conversation that it belongs to with the
+// message selected.
+func (ui *UI) ShowSearchResults(query string, results []SearchResult) {
+	p := ui.Printer()
+	ui.searchResults.Clear()
+	ui.searchResults.SetTitle(p.Sprintf("Search results for %q", tview.Escape(query)))
+	if len(results) == 0 {
+		ui.searchResults.AddItem(p.Sprintf("No messages found"), "", 0, nil)
+	}
+	for _, r := range results {
+		ui.searchResults.AddItem(r.Label, r.Snippet, 0, func() {
+			ui.jumpToMessage(r)
+		})
+	}
+	ui.buffers.SwitchToPage(searchPageName)
+	ui.app.SetFocus(ui.searchResults)
+	ui.redraw()
+}
+
+// jumpToMessage opens the conversation that a search result belongs to and
+// selects the message.
+func (ui *UI) jumpToMessage(r SearchResult) {
+	c, ok := ui.sidebar.conversations.GetItem(r.JID.String())
+	if !ok {
+		c = Conversation{
+			JID:  r.JID,
+			Room: r.Room,
+		}
+		if r.JID.Resourcepart() != "" {
+			c.Name = PrivateName(r.JID)
+		}
+	}
+	idx := ui.upsertConversation(c, ui.openConversation)
+	ui.sidebar.conversations.list.SetCurrentItem(idx)
+	ui.sidebar.dropDown.SetCurrentOption(0)
+	ui.buffers.SwitchToPage(chatPageName)
+	ui.chatsOpen.Set(true)
+	ui.handler(event.JumpToMessage{
+		Item: roster.Item{
+			JID:  c.JID,
+			Name: c.Name,
+		},
+		ID: r.ID,
+	})
+	ui.app.SetFocus(ui.history.TextView)
+}
+
+// SelectMessage selects the message with the given ID (or group chat stanza ID)
+// in the open conversation and scrolls to it.
+// It reports whether the message was found.
+func (ui *UI) SelectMessage(id string) bool {
+	ok := ui.history.selectMessage(id)
+	ui.redraw()
+	return ok
+}
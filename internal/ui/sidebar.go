@@ -9,6 +9,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
@@ -77,6 +78,7 @@ func newSidebar(p *message.Printer, ui *UI, statusSelect func()) *Sidebar {
 			return
 		}
 		main = strings.TrimPrefix(main, highlightTag)
+		main = strings.TrimSuffix(main, composingIndicator)
 		ui.statusBar.SetText(p.Sprintf("Chat: %q (%s)", main, secondary))
 	})
 	r.pages.AddAndSwitchToPage(r.conversations.list.GetTitle(), r.conversations, true)
@@ -210,6 +212,10 @@ func (s *Sidebar) InputHandler() func(event *tcell.EventKey, setFocus func(p tvi
 			s.deleteItem()
 		case 's':
 			s.statusSelect()
+		case 'S':
+			s.ui.ShowSyncPrompt()
+		case 'm':
+			s.notifySettings()
 		case '1', '2', '3', '4', '5', '6', '7', '8', '9', '0':
 			// Don't reset events, after a number we may provide an action such as
 			// '10j'.
@@ -242,6 +248,10 @@ func (s *Sidebar) InputHandler() func(event *tcell.EventKey, setFocus func(p tvi
 			case s.bookmarks.list.GetTitle():
 				s.ui.ShowAddBookmark()
 			}
+		case 'C':
+			s.ui.ShowCreateChannel()
+		case 'E':
+			s.configureChannel()
 		default:
 			_, item := s.pages.GetFrontPage()
 			if item != nil {
@@ -268,10 +278,23 @@ func (s *Sidebar) deleteItem() {
 		if !ok {
 			break
 		}
-		i.Delete(c.JID.String())
+		s.ui.DeleteConversation(c.JID)
 	}
 }
 
+func (s *Sidebar) notifySettings() {
+	_, item := s.pages.GetFrontPage()
+	i, ok := item.(*Conversations)
+	if !ok {
+		return
+	}
+	c, ok := i.GetSelected()
+	if !ok {
+		return
+	}
+	s.ui.ShowNotifySettings(c)
+}
+
 func (s *Sidebar) navigateDown() {
 	roster := s.getFrontList()
 	if roster == nil {
@@ -503,6 +526,22 @@ func (s Sidebar) Busy() {
 	s.setStatus("red", s.p.Sprintf("Busy"))
 }
 
+// Reconnecting sets the state of the roster to show that the connection was
+// lost and that we will try to reconnect after the given duration.
+func (s Sidebar) Reconnecting(in time.Duration) {
+	msg := s.p.Sprintf("Reconnecting in %s", in)
+	s.setStatus("orange", msg)
+	var width int
+	if s.Width > 4 {
+		width = s.Width - 4
+	}
+	label := "─ " + msg + " "
+	if fill := width - tview.TaggedStringWidth(label); fill > 0 {
+		label += strings.Repeat("─", fill)
+	}
+	s.statusButton.SetLabel(label)
+}
+
 // UpsertPresence updates an existing roster item or bookmark with a newly seen
 // resource or presence change.
 // If the item is not in any roster, false is returned.
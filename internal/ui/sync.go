@@ -0,0 +1,95 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package ui
+
+import (
+	"sync"
+
+	"mellium.im/communique/internal/ui/event"
+)
+
+// syncProgress is the last reported progress of syncing the history of all
+// conversations.
+type syncProgress struct {
+	m      sync.Mutex
+	done   int
+	total  int
+	paused bool
+}
+
+func (s *syncProgress) get() (done, total int, paused bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.done, s.total, s.paused
+}
+
+func (s *syncProgress) set(done, total int, paused bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.done, s.total, s.paused = done, total, paused
+}
+
+// SyncProgress shows the progress of syncing the history of all conversations
+// in the status bar.
+// Once done is equal to total the progress is hidden.
+func (ui *UI) SyncProgress(done, total int, paused bool) {
+	ui.syncProgress.set(done, total, paused)
+	p := ui.Printer()
+	switch {
+	case done >= total:
+		ui.syncBar.SetText("")
+	case paused:
+		ui.syncBar.SetText(p.Sprintf("History sync paused: %d/%d", done, total))
+	default:
+		ui.syncBar.SetText(p.Sprintf("Syncing history: %d/%d", done, total))
+	}
+	ui.redraw()
+}
+
+// ShowSyncPrompt shows the progress of syncing the history of all
+// conversations and lets the user pause, resume, or stop it.
+func (ui *UI) ShowSyncPrompt() {
+	const pageName = "sync"
+	p := ui.Printer()
+	var (
+		pauseButton  = p.Sprintf("Pause")
+		resumeButton = p.Sprintf("Resume")
+		stopButton   = p.Sprintf("Stop")
+		closeButton  = p.Sprintf("Close")
+	)
+	done, total, paused := ui.syncProgress.get()
+	mod := NewModal()
+	switch {
+	case done >= total:
+		mod.SetText(p.Sprintf("The history is not being synced."))
+		mod.AddButtons([]string{closeButton})
+	case paused:
+		mod.SetText(p.Sprintf("Syncing the history is paused after %d of %d conversations.", done, total))
+		mod.AddButtons([]string{resumeButton, stopButton, closeButton})
+	default:
+		mod.SetText(p.Sprintf("Synced the history of %d of %d conversations.", done, total))
+		mod.AddButtons([]string{pauseButton, stopButton, closeButton})
+	}
+	onEsc := func() {
+		ui.pages.HidePage(pageName)
+		ui.pages.RemovePage(pageName)
+	}
+	mod.SetDoneFunc(func(_ int, buttonLabel string) {
+		switch buttonLabel {
+		case pauseButton:
+			ui.handler(event.PauseSync{})
+		case resumeButton:
+			ui.handler(event.ResumeSync{})
+		case stopButton:
+			ui.handler(event.CancelSync{})
+		}
+		onEsc()
+	})
+	mod.SetInputCapture(modalClose(onEsc))
+	ui.pages.AddPage(pageName, mod, true, false)
+	ui.pages.ShowPage(pageName)
+	ui.pages.SendToFront(pageName)
+	ui.app.SetFocus(ui.pages)
+}
@@ -14,10 +14,12 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"regexp"
 	"strings"
 	"sync"
 	"text/tabwriter"
 	"text/template"
+	"time"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
@@ -44,6 +46,7 @@ const (
 	infoPageName        = "info"
 	setStatusPageName   = "set_status"
 	uiPageName          = "ui"
+	searchPageName      = "search_results"
 
 	statusOnline  = "online"
 	statusOffline = "offline"
@@ -70,26 +73,33 @@ func (b *syncBool) Get() bool {
 
 // UI is a widget that combines other widgets to make the main UI.
 type UI struct {
-	app          *tview.Application
-	flex         *tview.Flex
-	pages        *tview.Pages
-	buffers      *tview.Pages
-	history      *ConversationView
-	statusBar    *tview.TextView
-	sidebar      *Sidebar
-	sidebarWidth int
-	logWriter    *tview.TextView
-	handler      func(interface{})
-	redraw       func() *tview.Application
-	addr         string
-	passPrompt   chan string
-	chatsOpen    *syncBool
-	cmdPane      *commandsPane
-	debug        *log.Logger
-	logger       *log.Logger
-	p            *message.Printer
-	filePicker   []string
-	notify       []string
+	app           *tview.Application
+	flex          *tview.Flex
+	pages         *tview.Pages
+	buffers       *tview.Pages
+	history       *ConversationView
+	searchResults *tview.List
+	statusBar     *tview.TextView
+	syncBar       *tview.TextView
+	syncProgress  *syncProgress
+	sidebar       *Sidebar
+	sidebarWidth  int
+	logWriter     *tview.TextView
+	handler       func(interface{})
+	redraw        func() *tview.Application
+	addr          string
+	passPrompt    chan string
+	chatsOpen     *syncBool
+	dnd           *syncBool
+	cmdPane       *commandsPane
+	debug         *log.Logger
+	logger        *log.Logger
+	p             *message.Printer
+	filePicker    []string
+	notify        []string
+	highlights    []*regexp.Regexp
+	chatStates    bool
+	timeFormat    string
 }
 
 // Printer returns the message printer that the UI is using for translations.
@@ -196,6 +206,31 @@ func Notify(cmd []string) Option {
 	}
 }
 
+// ChatStates returns an option that controls whether chat state notifications
+// (eg. letting the other person know that you're typing) are sent.
+// They are sent by default.
+func ChatStates(send bool) Option {
+	return func(ui *UI) {
+		ui.chatStates = send
+	}
+}
+
+// defaultTimeFormat is the layout used to show the time that messages were
+// sent if none is configured.
+const defaultTimeFormat = "15:04"
+
+// TimeFormat returns an option that sets the layout (as understood by the time
+// package) used to show the time that messages were sent.
+// If layout is empty, the default of "15:04" is used.
+func TimeFormat(layout string) Option {
+	return func(ui *UI) {
+		if layout == "" {
+			layout = defaultTimeFormat
+		}
+		ui.timeFormat = layout
+	}
+}
+
 // Handle returns an option that configures an event handler which will be
 // called when the user performs certain actions in the UI.
 // Only one event handler can be registered, and subsequent calls to Handle will
@@ -241,6 +276,13 @@ func New(p *message.Printer, logger *log.Logger, opts ...Option) *UI {
 		SetBackgroundColor(tview.Styles.MoreContrastBackgroundColor).
 		SetBorder(false).
 		SetBorderPadding(0, 0, 2, 0)
+	syncBar := tview.NewTextView()
+	syncBar.
+		SetTextAlign(tview.AlignRight).
+		SetTextColor(tview.Styles.PrimaryTextColor).
+		SetBackgroundColor(tview.Styles.MoreContrastBackgroundColor).
+		SetBorder(false).
+		SetBorderPadding(0, 0, 0, 2)
 	buffers := tview.NewPages()
 	pages := tview.NewPages()
 
@@ -248,12 +290,17 @@ func New(p *message.Printer, logger *log.Logger, opts ...Option) *UI {
 		app:          app,
 		sidebarWidth: 25,
 		statusBar:    statusBar,
+		syncBar:      syncBar,
+		syncProgress: &syncProgress{},
 		handler:      func(interface{}) {},
 		redraw:       app.Draw,
 		buffers:      buffers,
 		pages:        pages,
 		passPrompt:   make(chan string),
 		chatsOpen:    &syncBool{},
+		dnd:          &syncBool{},
+		chatStates:   true,
+		timeFormat:   defaultTimeFormat,
 		debug:        log.New(io.Discard, "", 0),
 		logger:       logger,
 		p:            p,
@@ -304,11 +351,16 @@ func New(p *message.Printer, logger *log.Logger, opts ...Option) *UI {
 		AddItem(buffers, 0, 1, false)
 	ui.flex = tview.NewFlex().SetDirection(tview.FlexRow).
 		AddItem(ltrFlex, 0, 1, true).
-		AddItem(statusBar, 1, 1, false)
+		AddItem(tview.NewFlex().
+			AddItem(statusBar, 0, 1, false).
+			AddItem(syncBar, 0, 1, false), 1, 1, false)
 
 	ui.pages.AddPage(setStatusPageName, setStatusPage, true, false)
 	ui.pages.AddPage(uiPageName, ui.flex, true, true)
 	buffers.AddPage(cmdPageName, ui.cmdPane, true, false)
+
+	ui.searchResults = newSearchResults(ui)
+	buffers.AddPage(searchPageName, ui.searchResults, true, false)
 	ui.pages.AddPage(delRosterPageName, delRosterModal(p, func() {
 		ui.pages.HidePage(delRosterPageName)
 	}, func() {
@@ -350,6 +402,12 @@ func (ui *UI) MarkUnread(j, msgID string) bool {
 	return ui.sidebar.MarkUnread(j, msgID)
 }
 
+// MarkMentioned adds an unread mention to the count shown next to the given
+// conversation.
+func (ui *UI) MarkMentioned(j string) bool {
+	return ui.sidebar.conversations.MarkMentioned(j)
+}
+
 // RosterLen returns the length of the currently visible roster.
 func (ui *UI) RosterLen() int {
 	roster := ui.sidebar.getFrontList()
@@ -374,25 +432,59 @@ func (ui *UI) UpdateRoster(item RosterItem) {
 			firstUnread: item.firstUnread,
 			presences:   item.presences,
 		}
-		idx := ui.sidebar.conversations.Upsert(c, selected)
+		idx := ui.upsertConversation(c, selected)
 		ui.sidebar.conversations.list.SetCurrentItem(idx)
 		ui.sidebar.dropDown.SetCurrentOption(0)
 		selected(c)
 	})
+	// If the conversation was restored with unread messages before the roster
+	// was loaded, show them in the roster too.
+	bare := item.JID.Bare().String()
+	if c, ok := ui.sidebar.conversations.GetItem(bare); ok && ui.sidebar.conversations.Unread(bare) {
+		ui.sidebar.roster.MarkUnread(bare, c.firstUnread)
+	}
 	ui.redraw()
 }
 
 // UpdateConversations adds a roster item to the recent conversations list.
 func (ui *UI) UpdateConversations(c Conversation) {
-	ui.sidebar.conversations.Upsert(c, func(c Conversation) {
-		ui.buffers.SwitchToPage(chatPageName)
-		ui.chatsOpen.Set(true)
-		ui.handler(event.OpenChat(roster.Item{
-			JID:  c.JID,
-			Name: c.Name,
-		}))
-		ui.app.SetFocus(ui.buffers)
-	})
+	ui.upsertConversation(c, ui.openConversation)
+	ui.unmuteLater(c)
+	ui.redraw()
+}
+
+// openConversation opens the chat view for an item in the recent conversations
+// list.
+func (ui *UI) openConversation(c Conversation) {
+	ui.buffers.SwitchToPage(chatPageName)
+	ui.chatsOpen.Set(true)
+	ui.handler(event.OpenChat(roster.Item{
+		JID:  c.JID,
+		Name: c.Name,
+	}))
+	ui.app.SetFocus(ui.buffers)
+}
+
+// upsertConversation adds an item to the recent conversations list and emits
+// an event so that it can be saved.
+func (ui *UI) upsertConversation(c Conversation, action func(Conversation)) int {
+	idx := ui.sidebar.conversations.Upsert(c, action)
+	if item, ok := ui.sidebar.conversations.GetItem(c.JID.String()); ok {
+		ui.handler(event.UpdateConversation{
+			JID:        item.JID,
+			Name:       item.Name,
+			Room:       item.Room,
+			Notify:     string(item.Notify),
+			MutedUntil: item.MutedUntil,
+		})
+	}
+	return idx
+}
+
+// DeleteConversation removes an item from the recent conversations list.
+func (ui *UI) DeleteConversation(j jid.JID) {
+	ui.sidebar.conversations.Delete(j.String())
+	ui.handler(event.DeleteConversation(j))
 	ui.redraw()
 }
 
@@ -400,31 +492,37 @@ func (ui *UI) UpdateConversations(c Conversation) {
 func (ui *UI) UpdateBookmarks(item bookmarks.Channel) {
 	ui.handler(event.UpdateBookmark(item))
 	ui.sidebar.bookmarks.Upsert(item, func() {
-		selected := func(c Conversation) {
-			ui.buffers.SwitchToPage(chatPageName)
-			ui.chatsOpen.Set(true)
-			ui.handler(event.OpenChat(roster.Item{
-				JID:  item.JID,
-				Name: item.Name,
-			}))
-			ui.app.SetFocus(ui.buffers)
-		}
-		c := Conversation{
-			JID:  item.JID,
-			Name: item.Name,
-			Room: true,
-		}
-		idx := ui.sidebar.conversations.Upsert(c, selected)
-		ui.sidebar.conversations.list.SetCurrentItem(idx)
-		ui.sidebar.dropDown.SetCurrentOption(0)
-		selected(c)
-		ui.app.SetFocus(ui.buffers)
-		ui.handler(event.OpenChannel(item))
+		ui.JoinChannel(item)
+	})
+	ui.redraw()
+}
+
+// JoinChannel opens the conversation for a group chat and joins it.
+func (ui *UI) JoinChannel(item bookmarks.Channel) {
+	selected := func(c Conversation) {
+		ui.buffers.SwitchToPage(chatPageName)
+		ui.chatsOpen.Set(true)
 		ui.handler(event.OpenChat(roster.Item{
 			JID:  item.JID,
 			Name: item.Name,
 		}))
-	})
+		ui.app.SetFocus(ui.buffers)
+	}
+	c := Conversation{
+		JID:  item.JID,
+		Name: item.Name,
+		Room: true,
+	}
+	idx := ui.upsertConversation(c, selected)
+	ui.sidebar.conversations.list.SetCurrentItem(idx)
+	ui.sidebar.dropDown.SetCurrentOption(0)
+	selected(c)
+	ui.app.SetFocus(ui.buffers)
+	ui.handler(event.OpenChannel(item))
+	ui.handler(event.OpenChat(roster.Item{
+		JID:  item.JID,
+		Name: item.Name,
+	}))
 	ui.redraw()
 }
 
@@ -462,9 +560,114 @@ func (ui *UI) Offline(j jid.JID, self bool) {
 	ui.sidebar.UpsertPresence(j, statusOffline)
 }
 
+// SetLastSent records the last message that we sent to the given JID so that
+// it can be corrected by pressing up in the message input field.
+func (ui *UI) SetLastSent(j jid.JID, id, body string) {
+	ui.history.SetLastSent(j, id, body)
+}
+
+// MessageRegion records a message that is about to be written to the history
+// and returns the tview region tag that it should be wrapped in so that it can
+// be selected.
+func (ui *UI) MessageRegion(m Message) string {
+	return ui.history.writeRegion(m)
+}
+
+// SetHistoryCursor records an opaque cursor for the oldest message loaded into
+// the open conversation so that older messages can be loaded later.
+func (ui *UI) SetHistoryCursor(cursor int64) {
+	ui.history.msgM.Lock()
+	defer ui.history.msgM.Unlock()
+	ui.history.cursor = cursor
+}
+
+// HistoryCursor returns the cursor set by SetHistoryCursor, or 0 if the open
+// conversation has been cleared since it was set.
+func (ui *UI) HistoryCursor() int64 {
+	ui.history.msgM.Lock()
+	defer ui.history.msgM.Unlock()
+	return ui.history.cursor
+}
+
+// PrependHistory calls f, which should rewrite the open conversation with older
+// messages added to the start, and then scrolls so that the messages that were
+// visible before f was called don't move.
+func (ui *UI) PrependHistory(f func()) {
+	before := ui.history.wrappedLines()
+	row, column := ui.history.TextView.GetScrollOffset()
+	f()
+	after := ui.history.wrappedLines()
+	ui.history.TextView.ScrollTo(row+after-before, column)
+}
+
+// RewriteHistory calls f, which should rewrite the open conversation with the
+// same messages, and then scrolls back to where the conversation was before f
+// was called.
+// If the end of the conversation was visible it stays at the end so that new
+// messages are still followed.
+func (ui *UI) RewriteHistory(f func()) {
+	row, column := ui.history.TextView.GetScrollOffset()
+	_, _, _, height := ui.history.TextView.GetInnerRect()
+	atEnd := row+height >= ui.history.wrappedLines()
+	f()
+	if atEnd {
+		ui.history.TextView.ScrollToEnd()
+		return
+	}
+	ui.history.TextView.ScrollTo(row, column)
+}
+
+// FormatTime formats the time that a message was sent in the local timezone
+// using the configured time format.
+func (ui *UI) FormatTime(t time.Time) string {
+	return t.Local().Format(ui.timeFormat)
+}
+
+// DaySeparator returns a line that should be written before a message sent at
+// t if it was sent on a different day than the last message written to the
+// open conversation, or an empty string if no separator is needed.
+func (ui *UI) DaySeparator(t time.Time) string {
+	return ui.history.daySeparator(t)
+}
+
+// SetReceipt changes the delivery status shown next to the message with the
+// given ID if it is in the open conversation.
+func (ui *UI) SetReceipt(id string, status ReceiptStatus) {
+	ui.history.setReceipt(id, status)
+}
+
+// ClearHistory removes all messages from the history.
+func (ui *UI) ClearHistory() {
+	ui.history.clear()
+}
+
+// ChatState shows a chat state notification (eg. "composing") received from
+// the given JID.
+func (ui *UI) ChatState(j jid.JID, state string) {
+	if ui.sidebar.conversations.SetChatState(j.Bare().String(), state) {
+		ui.redraw()
+	}
+}
+
+// ChannelDisconnected marks a group chat in the conversations list while we
+// are disconnected from it, or clears the mark once we rejoin.
+func (ui *UI) ChannelDisconnected(room jid.JID, disconnected bool) {
+	if ui.sidebar.conversations.SetDisconnected(room.Bare().String(), disconnected) {
+		ui.redraw()
+	}
+}
+
+// Reconnecting shows that the connection was lost and when we will next try
+// to reconnect.
+func (ui *UI) Reconnecting(in time.Duration) {
+	ui.sidebar.Reconnecting(in)
+	ui.redraw()
+}
+
 // Online sets the state of the roster to show the user as online.
 func (ui *UI) Online(j jid.JID, self bool) {
 	if self {
+		ui.dnd.Set(false)
 		ui.sidebar.Online()
 		ui.redraw()
 	}
@@ -474,6 +677,7 @@ func (ui *UI) Online(j jid.JID, self bool) {
 // Away sets the state of the roster to show the user as away.
 func (ui *UI) Away(j jid.JID, self bool) {
 	if self {
+		ui.dnd.Set(false)
 		ui.sidebar.Away()
 		ui.redraw()
 	}
@@ -481,8 +685,10 @@ func (ui *UI) Away(j jid.JID, self bool) {
 }
 
 // Busy sets the state of the roster to show the user as busy.
+// While we are busy notifications are not shown.
 func (ui *UI) Busy(j jid.JID, self bool) {
 	if self {
+		ui.dnd.Set(true)
 		ui.sidebar.Busy()
 		ui.redraw()
 	}
@@ -532,6 +738,42 @@ func (ui *UI) ShowQuitPrompt() {
 	ui.app.SetFocus(ui.pages)
 }
 
+// ShowError shows a modal with an error message.
+func (ui *UI) ShowError(text string) {
+	p := ui.Printer()
+	const errorPageName = "error"
+	onEsc := func() {
+		ui.pages.HidePage(errorPageName)
+		ui.pages.RemovePage(errorPageName)
+	}
+	mod := NewModal().
+		SetText(text).
+		AddButtons([]string{p.Sprintf("OK")}).
+		SetDoneFunc(func(int, string) {
+			onEsc()
+		})
+	mod.SetInputCapture(modalClose(onEsc))
+	ui.pages.AddPage(errorPageName, mod, true, false)
+	ui.pages.ShowPage(errorPageName)
+	ui.pages.SendToFront(errorPageName)
+	ui.app.SetFocus(ui.pages)
+	ui.redraw()
+}
+
+// showReactions asks the user to pick a reaction and calls onReact with it.
+func (ui *UI) showReactions(onReact func(string)) {
+	const pageName = "reactions"
+	mod := reactionsModal(ui.Printer(), func() {
+		ui.pages.HidePage(pageName)
+		ui.pages.RemovePage(pageName)
+		ui.app.SetFocus(ui.history.TextView)
+	}, onReact)
+	ui.pages.AddPage(pageName, mod, true, false)
+	ui.pages.ShowPage(pageName)
+	ui.pages.SendToFront(pageName)
+	ui.app.SetFocus(ui.pages)
+}
+
 // ShowAddBookmark asks the user for a new JID.
 func (ui *UI) ShowAddBookmark() {
 	const (
@@ -588,6 +830,10 @@ func (ui *UI) ShowAddRoster() {
 	l := len(ui.sidebar.roster.items) + len(ui.sidebar.conversations.items)
 	autocomplete := make([]jid.JID, 0, l)
 	for _, item := range ui.sidebar.conversations.items {
+		// Private conversations in channels can't be added to the roster.
+		if item.JID.Resourcepart() != "" {
+			continue
+		}
 		bare := item.JID.Bare()
 		if _, ok := ui.sidebar.roster.items[bare.String()]; ok {
 			continue
@@ -881,12 +1127,35 @@ i, Enter: open chat
 I: more info
 o, O: open next/prev unread
 dd: remove contact
+m: notification settings for conversation
 !: execute command
 s: change status
+S: pause, resume, or stop history sync
+C: create channel
+E: configure channel
 
 [::b]Chat[::-]
 
-Ctrl+u: upload file(s)`).
+Ctrl+u: upload file(s)
+↑: correct last message
+Enter: select messages (in history)
+j, k: move selection
+r: reply to selected message
++: react to selected message
+D: retract selected message
+/: search history
+o: show/hide occupants (in group chats)
+i: invite contact (in group chats)
+n: change nickname (in group chats)
+Tab: focus occupants
+I, Enter: occupant info (in occupants)
+m: private message occupant (in occupants)
+K, B: kick, ban occupant (in occupants)
+v: grant/revoke voice (in occupants)
+r, a: change role, affiliation (in occupants)
+/kick, /ban, /voice, /devoice, /role, /affiliation: moderate (in group chats)
+/invite: invite someone (in group chats)
+/nick: change nickname (in group chats)`).
 		SetDoneFunc(func(int, string) {
 			onEsc()
 		})
@@ -930,21 +1199,26 @@ func (ui *UI) GetRosterJID() jid.JID {
 	return jid.JID{}
 }
 
+// statusIcon returns the icon used to show a presence status.
+func statusIcon(status string) string {
+	switch status {
+	case statusOnline:
+		return "●"
+	case statusBusy:
+		return "◐"
+	case statusAway:
+		return "◓"
+	case statusOffline:
+		return "◯"
+	}
+	return ""
+}
+
 func formatPresence(p []presence) string {
 	var buf strings.Builder
 	tabWriter := tabwriter.NewWriter(&buf, 0, 0, 1, ' ', 0)
 	for _, pres := range p {
-		icon := ""
-		switch pres.Status {
-		case statusOnline:
-			icon = "●"
-		case statusBusy:
-			icon = "◐"
-		case statusAway:
-			icon = "◓"
-		case statusOffline:
-			icon = "◯"
-		}
+		icon := statusIcon(pres.Status)
 		resPart := pres.From.Resourcepart()
 		if resPart != "" {
 			/* #nosec */
@@ -1144,6 +1418,12 @@ func (ui *UI) ShowRosterInfo() {
 	ui.app.SetFocus(ui.pages)
 }
 
+// CancelEdit stops correcting or replying to a message in the open
+// conversation, eg. because another conversation is being opened.
+func (ui *UI) CancelEdit() {
+	ui.history.resetEdit()
+}
+
 // SelectRoster moves the input selection back to the roster and shows the logs
 // view.
 func (ui *UI) SelectRoster() {
@@ -1217,10 +1497,10 @@ func (ui *UI) handleInput(event *tcell.EventKey) *tcell.EventKey {
 	return event
 }
 
-// Notify runs the notification command.
+// Notify runs the notification command unless our status is busy.
 func (ui *UI) Notify() {
 	p := ui.Printer()
-	if len(ui.notify) == 0 {
+	if len(ui.notify) == 0 || ui.dnd.Get() {
 		return
 	}
 	cmd := exec.Command(ui.notify[0], ui.notify[1:]...) // #nosec G204
@@ -22,6 +22,7 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"regexp"
 	"runtime"
 	"strings"
 	"syscall"
@@ -175,6 +176,19 @@ Try running '%s config' to generate a default config file.`, err, os.Args[0])
 			sigs := make(chan os.Signal, 1)
 			signal.Notify(sigs, os.Interrupt, syscall.SIGQUIT, syscall.SIGTERM)
 
+			highlights := make([]*regexp.Regexp, 0, len(cfg.UI.HighlightWords)+len(cfg.UI.HighlightPatterns))
+			for _, word := range cfg.UI.HighlightWords {
+				highlights = append(highlights, ui.WordRule(word))
+			}
+			for _, pattern := range cfg.UI.HighlightPatterns {
+				re, err := regexp.Compile(pattern)
+				if err != nil {
+					logger.Print(p.Sprintf("error parsing highlight pattern %q: %v", pattern, err))
+					continue
+				}
+				highlights = append(highlights, re)
+			}
+
 			pane := ui.New(
 				p,
 				logger,
@@ -183,6 +197,9 @@ Try running '%s config' to generate a default config file.`, err, os.Args[0])
 				ui.ShowStatus(!cfg.UI.HideStatus),
 				ui.FilePicker(cfg.UI.FilePicker),
 				ui.Notify(cfg.UI.Notify),
+				ui.Highlights(highlights),
+				ui.ChatStates(!cfg.UI.DisableChatStates),
+				ui.TimeFormat(cfg.UI.TimeFormat),
 				ui.RosterWidth(cfg.UI.Width))
 			uiShutdown = pane.Stop
 
@@ -283,8 +300,20 @@ Go %s %s
 				client.RosterVer(rosterVer),
 				client.Printer(p),
 			)
-			c.Handler(newClientHandler(c, pane, db, logger, debug))
-			pane.Handle(newUIHandler(acct, pane, db, c, logger, debug))
+			// Restore the conversations list before we connect (and before we're
+			// handling UI events so that they aren't saved again) so that it is
+			// available even if we're offline.
+			func() {
+				ctx, cancel := context.WithTimeout(context.Background(), timeout)
+				defer cancel()
+				err := loadConversations(ctx, pane, db)
+				if err != nil {
+					logger.Print(p.Sprintf("error restoring conversations: %v", err))
+				}
+			}()
+			historySync := newHistorySync(pane.SyncProgress)
+			c.Handler(newClientHandler(c, pane, db, historySync, logger, debug))
+			pane.Handle(newUIHandler(acct, pane, db, c, historySync, logger, debug))
 
 			go func() {
 				ctx, cancel := context.WithTimeout(context.Background(), 3*timeout)
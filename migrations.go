@@ -27,5 +27,151 @@ func Migrations() []storage.Migration {
 			delete from sqlite_master where type in ('view', 'table', 'index', 'trigger');
 			PRAGMA writable_schema = 0;`,
 		},
+		{
+			// Message corrections (XEP-0308).
+			// The body of the message is always updated to the latest correction and
+			// any previous bodies are kept here.
+			Version: 2,
+			Up: `
+			CREATE TABLE IF NOT EXISTS messageEdits (
+				id      INTEGER PRIMARY KEY NOT NULL,
+				message INTEGER             NOT NULL,
+				body    TEXT,
+				edited  INTEGER             NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER)),
+
+				FOREIGN KEY (message) REFERENCES messages(id) ON DELETE CASCADE
+			);`,
+			Down: `DROP TABLE IF EXISTS messageEdits;`,
+		},
+		{
+			// Message retraction (XEP-0424) and moderation (XEP-0425).
+			// Retracted messages are kept as tombstones with no body.
+			Version: 3,
+			Up:      `ALTER TABLE messages ADD COLUMN retracted BOOLEAN NOT NULL DEFAULT FALSE;`,
+			Down:    `ALTER TABLE messages DROP COLUMN retracted;`,
+		},
+		{
+			// Message reactions (XEP-0444).
+			// Each row is a single reaction from a single sender, the full set of
+			// reactions from a sender is replaced whenever they send a new one.
+			Version: 4,
+			Up: `
+			CREATE TABLE IF NOT EXISTS reactions (
+				id       INTEGER PRIMARY KEY NOT NULL,
+				message  INTEGER             NOT NULL,
+				sender   TEXT                NOT NULL,
+				reaction TEXT                NOT NULL,
+
+				FOREIGN KEY (message) REFERENCES messages(id) ON DELETE CASCADE,
+				UNIQUE (message, sender, reaction)
+			);`,
+			Down: `DROP TABLE IF EXISTS reactions;`,
+		},
+		{
+			// Message replies (XEP-0461).
+			// The body is stored with the fallback quote, and replyStart and replyEnd
+			// are the range of the body (in code points) that contains it.
+			Version: 5,
+			Up: `
+			ALTER TABLE messages ADD COLUMN replyID    TEXT;
+			ALTER TABLE messages ADD COLUMN replyStart INTEGER;
+			ALTER TABLE messages ADD COLUMN replyEnd   INTEGER;`,
+			Down: `
+			ALTER TABLE messages DROP COLUMN replyID;
+			ALTER TABLE messages DROP COLUMN replyStart;
+			ALTER TABLE messages DROP COLUMN replyEnd;`,
+		},
+		{
+			// Chat markers (XEP-0333).
+			// Received messages are displayed once we've read them on any device,
+			// and sent messages are displayed once the recipient has read them.
+			Version: 6,
+			Up: `
+			ALTER TABLE messages ADD COLUMN markable  BOOLEAN NOT NULL DEFAULT FALSE;
+			ALTER TABLE messages ADD COLUMN displayed BOOLEAN NOT NULL DEFAULT FALSE;`,
+			Down: `
+			ALTER TABLE messages DROP COLUMN markable;
+			ALTER TABLE messages DROP COLUMN displayed;`,
+		},
+		{
+			// Messages that we sent but that the server returned an error for.
+			Version: 7,
+			Up:      `ALTER TABLE messages ADD COLUMN failed BOOLEAN NOT NULL DEFAULT FALSE;`,
+			Down:    `ALTER TABLE messages DROP COLUMN failed;`,
+		},
+		{
+			// The recent conversations list.
+			// Conversations are shown in the order they were first opened and
+			// anything received after lastRead is considered unread.
+			Version: 8,
+			Up: `
+			CREATE TABLE IF NOT EXISTS conversations (
+				jid      TEXT    PRIMARY KEY NOT NULL,
+				name     TEXT,
+				room     BOOLEAN NOT NULL DEFAULT FALSE,
+				muted    BOOLEAN NOT NULL DEFAULT FALSE,
+				position INTEGER NOT NULL,
+				lastRead INTEGER,
+
+				FOREIGN KEY (lastRead) REFERENCES messages(id) ON DELETE SET NULL
+			);`,
+			Down: `DROP TABLE IF EXISTS conversations;`,
+		},
+		{
+			// Full text search over message bodies.
+			// The index doesn't store its own copy of the bodies and is kept up to
+			// date with the messages table by the triggers.
+			Version: 9,
+			Up: `
+			CREATE VIRTUAL TABLE IF NOT EXISTS messagesFTS USING fts5(
+				body,
+				content='messages',
+				content_rowid='id'
+			);
+			INSERT INTO messagesFTS(messagesFTS) VALUES ('rebuild');
+
+			CREATE TRIGGER IF NOT EXISTS messagesFTSInsert AFTER INSERT ON messages BEGIN
+				INSERT INTO messagesFTS(rowid, body) VALUES (new.id, new.body);
+			END;
+			CREATE TRIGGER IF NOT EXISTS messagesFTSDelete AFTER DELETE ON messages BEGIN
+				INSERT INTO messagesFTS(messagesFTS, rowid, body) VALUES ('delete', old.id, old.body);
+			END;
+			CREATE TRIGGER IF NOT EXISTS messagesFTSUpdate AFTER UPDATE OF body ON messages BEGIN
+				INSERT INTO messagesFTS(messagesFTS, rowid, body) VALUES ('delete', old.id, old.body);
+				INSERT INTO messagesFTS(rowid, body) VALUES (new.id, new.body);
+			END;`,
+			Down: `
+			DROP TRIGGER IF EXISTS messagesFTSInsert;
+			DROP TRIGGER IF EXISTS messagesFTSDelete;
+			DROP TRIGGER IF EXISTS messagesFTSUpdate;
+			DROP TABLE IF EXISTS messagesFTS;`,
+		},
+		{
+			// Per-conversation notification policy ("always", "mentions", "never",
+			// or empty for the default) which replaces the muted flag, and the time
+			// until which notifications are muted.
+			Version: 10,
+			Up: `
+			ALTER TABLE conversations ADD COLUMN notify     TEXT NOT NULL DEFAULT '';
+			ALTER TABLE conversations ADD COLUMN mutedUntil INTEGER;
+			UPDATE conversations SET notify='never' WHERE muted;
+			ALTER TABLE conversations DROP COLUMN muted;`,
+			Down: `
+			ALTER TABLE conversations ADD COLUMN muted BOOLEAN NOT NULL DEFAULT FALSE;
+			UPDATE conversations SET muted=TRUE WHERE notify='never';
+			ALTER TABLE conversations DROP COLUMN notify;
+			ALTER TABLE conversations DROP COLUMN mutedUntil;`,
+		},
+		{
+			// The occupant that sent a group chat message and their real JID if the
+			// group chat exposes it, so that only they can correct it (XEP-0308).
+			Version: 11,
+			Up: `
+			ALTER TABLE messages ADD COLUMN occupant TEXT;
+			ALTER TABLE messages ADD COLUMN realJID  TEXT;`,
+			Down: `
+			ALTER TABLE messages DROP COLUMN occupant;
+			ALTER TABLE messages DROP COLUMN realJID;`,
+		},
 	}
 }
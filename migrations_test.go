@@ -0,0 +1,131 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"mellium.im/communique/internal/storage"
+	"mellium.im/xmpp/jid"
+)
+
+// migrate runs the migrations needed to go from the current schema version of
+// db to target.
+func migrate(ctx context.Context, t *testing.T, db *sql.DB, target uint) {
+	t.Helper()
+	var current uint
+	err := db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&current)
+	if err != nil {
+		t.Fatalf("error getting schema version: %v", err)
+	}
+	for version, script := range storage.Migrations(Migrations()).Run(current, target) {
+		_, err = db.ExecContext(ctx, script)
+		if err != nil {
+			t.Fatalf("error migrating from %d to %d: %v", current, target, err)
+		}
+		_, err = db.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version=%d", version))
+		if err != nil {
+			t.Fatalf("error setting schema version: %v", err)
+		}
+	}
+	if current > target {
+		// While downgrading each script is reported with the version that it
+		// undoes, so the last one isn't the target.
+		_, err = db.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version=%d", target))
+		if err != nil {
+			t.Fatalf("error setting schema version: %v", err)
+		}
+	}
+}
+
+// openRawDB opens the test database in dir without running any migrations.
+func openRawDB(t *testing.T, dir string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	// Each connection to the database would have its own schema version.
+	db.SetMaxOpenConns(1)
+	return db
+}
+
+// TestMigrationsDown checks that every migration after the initial schema can
+// be undone and then applied again.
+func TestMigrationsDown(t *testing.T) {
+	ctx := context.Background()
+	db := openRawDB(t, t.TempDir())
+	defer db.Close()
+	m := Migrations()
+	migrate(ctx, t, db, 1)
+	for _, cur := range m[1:] {
+		t.Run(strconv.FormatUint(uint64(cur.Version), 10), func(t *testing.T) {
+			migrate(ctx, t, db, cur.Version)
+			migrate(ctx, t, db, cur.Version-1)
+			migrate(ctx, t, db, cur.Version)
+		})
+	}
+}
+
+// TestMigrationsData checks that data stored before a migration is converted.
+func TestMigrationsData(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	raw := openRawDB(t, dir)
+	migrate(ctx, t, raw, 1)
+	_, err := raw.ExecContext(ctx, `
+INSERT INTO messages (sent, toAttr, fromAttr, idAttr, body, stanzaType, originID, rosterJID)
+	VALUES (FALSE, 'hag66@example.net', 'juliet@example.net', '1', 'wherefore art thou', 'chat', '1', 'juliet@example.net')`)
+	if err != nil {
+		t.Fatalf("error inserting message: %v", err)
+	}
+	migrate(ctx, t, raw, 8)
+	_, err = raw.ExecContext(ctx, `
+INSERT INTO conversations (jid, muted, position)
+	VALUES ('juliet@example.net', TRUE, 1), ('romeo@example.net', FALSE, 2)`)
+	if err != nil {
+		t.Fatalf("error inserting conversations: %v", err)
+	}
+	err = raw.Close()
+	if err != nil {
+		t.Fatalf("error closing database: %v", err)
+	}
+
+	db := openTestDB(t, dir)
+	checkFTS(t, db)
+
+	// Messages stored before the search index was created were indexed.
+	var found []string
+	iter := db.Search(ctx, "wherefore", jid.JID{}, 10)
+	for iter.Next() {
+		found = append(found, iter.Result().ID)
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatalf("error searching: %v", err)
+	}
+	if len(found) != 1 || found[0] != "1" {
+		t.Errorf("wrong search results: want=[1], got=%v", found)
+	}
+
+	// The muted flag was converted to a notification policy.
+	notify := make(map[string]string)
+	err = db.ForConversations(ctx, func(c storage.Conversation) {
+		notify[c.JID.String()] = c.Notify
+	})
+	if err != nil {
+		t.Fatalf("error listing conversations: %v", err)
+	}
+	if n := notify["juliet@example.net"]; n != "never" {
+		t.Errorf("wrong notification policy for muted conversation: want=never, got=%q", n)
+	}
+	if n, ok := notify["romeo@example.net"]; !ok || n != "" {
+		t.Errorf("wrong notification policy for unmuted conversation: want=\"\", got=%q", n)
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"testing"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/communique/internal/storage"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// chatMsg returns a message sent to us by juliet@example.net.
+func chatMsg(id, body string) event.ChatMessage {
+	return event.ChatMessage{
+		Message: stanza.Message{
+			ID:   id,
+			From: jid.MustParse("juliet@example.net/balcony"),
+			To:   jid.MustParse(testAccount + "/pda"),
+			Type: stanza.ChatMessage,
+		},
+		Body: body,
+	}
+}
+
+// correction returns a message that corrects the message with the given ID.
+func correction(id, replaceID, body string) event.ChatMessage {
+	msg := chatMsg(id, body)
+	msg.Replace.ID = replaceID
+	return msg
+}
+
+// retraction returns a message that retracts the message with the given ID.
+func retraction(id, retractID string) event.ChatMessage {
+	msg := chatMsg(id, "")
+	msg.Retract.ID = retractID
+	return msg
+}
+
+var searchTestCases = [...]struct {
+	insert  []event.ChatMessage
+	correct []event.ChatMessage
+	retract []event.ChatMessage
+	query   string
+	with    string
+	found   []string
+}{
+	0: {},
+	1: {
+		insert: []event.ChatMessage{chatMsg("1", "wherefore art thou")},
+		query:  "wherefore",
+		found:  []string{"1"},
+	},
+	2: {
+		// The last word matches as a prefix, but the others don't.
+		insert: []event.ChatMessage{chatMsg("1", "wherefore art thou")},
+		query:  "wherefore th",
+		found:  []string{"1"},
+	},
+	3: {
+		insert: []event.ChatMessage{chatMsg("1", "wherefore art thou")},
+		query:  "where thou",
+	},
+	4: {
+		// All words have to match.
+		insert: []event.ChatMessage{
+			chatMsg("1", "wherefore art thou"),
+			chatMsg("2", "art thou not"),
+		},
+		query: "art thou",
+		found: []string{"2", "1"},
+	},
+	5: {
+		insert: []event.ChatMessage{
+			chatMsg("1", "wherefore art thou"),
+			chatMsg("2", "art thou not"),
+		},
+		query: "wherefore not",
+	},
+	6: {
+		// Corrections replace the old body in the index.
+		insert:  []event.ChatMessage{chatMsg("1", "wherefore art thou")},
+		correct: []event.ChatMessage{correction("2", "1", "what light")},
+		query:   "wherefore",
+	},
+	7: {
+		insert:  []event.ChatMessage{chatMsg("1", "wherefore art thou")},
+		correct: []event.ChatMessage{correction("2", "1", "what light")},
+		query:   "light",
+		found:   []string{"1"},
+	},
+	8: {
+		// Retracted messages are removed from the index.
+		insert:  []event.ChatMessage{chatMsg("1", "wherefore art thou")},
+		retract: []event.ChatMessage{retraction("2", "1")},
+		query:   "wherefore",
+	},
+	9: {
+		// FTS5 syntax is searched for as text.
+		insert: []event.ChatMessage{
+			chatMsg("1", "wherefore"),
+			chatMsg("2", "thou"),
+		},
+		query: "wherefore OR thou",
+	},
+	10: {
+		insert: []event.ChatMessage{chatMsg("1", "wherefore")},
+		query:  "NOT thou",
+	},
+	11: {
+		insert: []event.ChatMessage{chatMsg("1", `say "wherefore"`)},
+		query:  `"wherefore`,
+		found:  []string{"1"},
+	},
+	12: {
+		insert: []event.ChatMessage{chatMsg("1", "wherefore")},
+		query:  "body:wherefore",
+	},
+	13: {
+		insert: []event.ChatMessage{chatMsg("1", "wherefore")},
+		query:  "NEAR(wherefore) ^wherefore -wherefore * (",
+	},
+	14: {
+		// Only the given conversation is searched.
+		insert: []event.ChatMessage{chatMsg("1", "wherefore")},
+		query:  "wherefore",
+		with:   "romeo@example.net",
+	},
+	15: {
+		insert: []event.ChatMessage{chatMsg("1", "wherefore")},
+		query:  "wherefore",
+		with:   "juliet@example.net",
+		found:  []string{"1"},
+	},
+}
+
+func TestSearch(t *testing.T) {
+	for i, tc := range searchTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			ctx := context.Background()
+			db := openTestDB(t, t.TempDir())
+			addr := jid.MustParse(testAccount + "/pda")
+			for _, msg := range tc.insert {
+				err := db.InsertMsg(ctx, false, msg, addr)
+				if err != nil {
+					t.Fatalf("error inserting message: %v", err)
+				}
+			}
+			for _, msg := range tc.correct {
+				ok, err := db.CorrectMsg(ctx, msg, addr)
+				if err != nil || !ok {
+					t.Fatalf("error correcting message: %t, %v", ok, err)
+				}
+			}
+			for _, msg := range tc.retract {
+				ok, err := db.RetractMsg(ctx, msg, addr)
+				if err != nil || !ok {
+					t.Fatalf("error retracting message: %t, %v", ok, err)
+				}
+			}
+			checkFTS(t, db)
+
+			var with jid.JID
+			if tc.with != "" {
+				with = jid.MustParse(tc.with)
+			}
+			var found []string
+			iter := db.Search(ctx, tc.query, with, 10)
+			for iter.Next() {
+				found = append(found, iter.Result().ID)
+			}
+			err := iter.Err()
+			if err != nil {
+				t.Fatalf("error searching: %v", err)
+			}
+			err = iter.Close()
+			if err != nil {
+				t.Fatalf("error closing iter: %v", err)
+			}
+			if !slices.Equal(found, tc.found) {
+				t.Errorf("wrong results: want=%v, got=%v", tc.found, found)
+			}
+		})
+	}
+}
+
+// TestSearchDelete checks that deleted messages are removed from the index.
+// Nothing deletes single messages yet, but the trigger still has to keep the
+// index in sync if it happens.
+func TestSearchDelete(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t, t.TempDir())
+	err := db.InsertMsg(ctx, false, chatMsg("1", "wherefore art thou"), jid.MustParse(testAccount+"/pda"))
+	if err != nil {
+		t.Fatalf("error inserting message: %v", err)
+	}
+	_, err = db.ExecContext(ctx, `DELETE FROM messages`)
+	if err != nil {
+		t.Fatalf("error deleting message: %v", err)
+	}
+	checkFTS(t, db)
+	var n int
+	err = db.QueryRowContext(ctx, `SELECT COUNT(*) FROM messagesFTS WHERE messagesFTS MATCH 'wherefore'`).Scan(&n)
+	if err != nil {
+		t.Fatalf("error querying index: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("deleted message still in index: found %d rows", n)
+	}
+}
+
+// checkFTS fails the test if the full text search index does not match the
+// messages table.
+func checkFTS(t *testing.T, db *storage.DB) {
+	t.Helper()
+	_, err := db.ExecContext(context.Background(), `INSERT INTO messagesFTS(messagesFTS, rank) VALUES ('integrity-check', 1)`)
+	if err != nil {
+		t.Fatalf("search index out of sync: %v", err)
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/communique/internal/storage"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+const testAccount = "hag66@example.net"
+
+// openTestDB opens a database with all of the migrations applied in the
+// directory dir.
+func openTestDB(t *testing.T, dir string) *storage.DB {
+	t.Helper()
+	db, err := storage.OpenDB(context.Background(), "communiqué", testAccount, filepath.Join(dir, "test.db"), Migrations(), message.NewPrinter(language.English), log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	t.Cleanup(func() {
+		err := db.Close()
+		if err != nil {
+			t.Errorf("error closing database: %v", err)
+		}
+	})
+	return db
+}
+
+// groupChatMsg returns a message sent to a group chat by the occupant from.
+func groupChatMsg(from, realJID, id, body string) event.ChatMessage {
+	msg := event.ChatMessage{
+		Message: stanza.Message{
+			ID:   id,
+			From: jid.MustParse(from),
+			To:   jid.MustParse(testAccount + "/pda"),
+			Type: stanza.GroupChatMessage,
+		},
+		Body: body,
+	}
+	if realJID != "" {
+		msg.RealJID = jid.MustParse(realJID)
+	}
+	return msg
+}
+
+var correctGroupChatTestCases = [...]struct {
+	orig    event.ChatMessage
+	correct event.ChatMessage
+	ok      bool
+}{
+	0: {
+		orig:    groupChatMsg("coven@chat.example.net/secondwitch", "", "1", "foo"),
+		correct: groupChatMsg("coven@chat.example.net/secondwitch", "", "2", "bar"),
+		ok:      true,
+	},
+	1: {
+		// Someone else can't correct the message.
+		orig:    groupChatMsg("coven@chat.example.net/secondwitch", "", "1", "foo"),
+		correct: groupChatMsg("coven@chat.example.net/thirdwitch", "", "2", "bar"),
+	},
+	2: {
+		// The sender changed their nickname.
+		orig:    groupChatMsg("coven@chat.example.net/secondwitch", "witch@example.net", "1", "foo"),
+		correct: groupChatMsg("coven@chat.example.net/thirdwitch", "witch@example.net/broom", "2", "bar"),
+		ok:      true,
+	},
+	3: {
+		// The nickname was taken by someone else.
+		orig:    groupChatMsg("coven@chat.example.net/secondwitch", "witch@example.net", "1", "foo"),
+		correct: groupChatMsg("coven@chat.example.net/secondwitch", "crone@example.net", "2", "bar"),
+	},
+	4: {
+		// The real JID of the sender of the correction isn't known.
+		orig:    groupChatMsg("coven@chat.example.net/secondwitch", "witch@example.net", "1", "foo"),
+		correct: groupChatMsg("coven@chat.example.net/secondwitch", "", "2", "bar"),
+		ok:      true,
+	},
+	5: {
+		// The same ID in a different group chat.
+		orig:    groupChatMsg("coven@chat.example.net/secondwitch", "", "1", "foo"),
+		correct: groupChatMsg("circle@chat.example.net/secondwitch", "", "2", "bar"),
+	},
+}
+
+func TestCorrectGroupChat(t *testing.T) {
+	for i, tc := range correctGroupChatTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			ctx := context.Background()
+			db := openTestDB(t, t.TempDir())
+			addr := jid.MustParse(testAccount + "/pda")
+			err := db.InsertMsg(ctx, false, tc.orig, addr)
+			if err != nil {
+				t.Fatalf("error inserting message: %v", err)
+			}
+			tc.correct.Replace.ID = tc.orig.ID
+			ok, err := db.CorrectMsg(ctx, tc.correct, addr)
+			if err != nil {
+				t.Fatalf("error correcting message: %v", err)
+			}
+			if ok != tc.ok {
+				t.Errorf("wrong result: want=%t, got=%t", tc.ok, ok)
+			}
+		})
+	}
+}
+
+// historyIDs are the IDs of the messages inserted by insertHistory in the order
+// that they should be returned.
+// Row IDs are assigned in the order that the messages are inserted, which isn't
+// the order that they were sent in, and c, d, and e have the same timestamp.
+var historyIDs = []string{"a", "c", "d", "e", "b", "f"}
+
+// insertHistory inserts the messages in historyIDs and returns their row IDs in
+// the same order.
+func insertHistory(t *testing.T, db *storage.DB) []int64 {
+	t.Helper()
+	ctx := context.Background()
+	addr := jid.MustParse(testAccount + "/pda")
+	for _, m := range []struct {
+		id    string
+		delay int64
+	}{
+		{id: "a", delay: 100},
+		{id: "b", delay: 300},
+		{id: "c", delay: 200},
+		{id: "d", delay: 200},
+		{id: "e", delay: 200},
+		{id: "f", delay: 400},
+	} {
+		msg := chatMsg(m.id, m.id)
+		msg.Delay.Time = time.Unix(m.delay, 0)
+		err := db.InsertMsg(ctx, true, msg, addr)
+		if err != nil {
+			t.Fatalf("error inserting message: %v", err)
+		}
+	}
+
+	ids, rowIDs := queryHistory(t, db, storage.Page{})
+	if !slices.Equal(ids, historyIDs) {
+		t.Fatalf("wrong history order: want=%v, got=%v", historyIDs, ids)
+	}
+	return rowIDs
+}
+
+// queryHistory returns the IDs and row IDs of a page of the history with
+// juliet@example.net.
+func queryHistory(t *testing.T, db *storage.DB, page storage.Page) ([]string, []int64) {
+	t.Helper()
+	var ids []string
+	var rowIDs []int64
+	iter := db.QueryHistory(context.Background(), "juliet@example.net", stanza.ChatMessage, page)
+	for iter.Next() {
+		ids = append(ids, iter.Message().ID)
+		rowIDs = append(rowIDs, iter.RowID())
+	}
+	err := iter.Err()
+	if err != nil {
+		t.Fatalf("error querying history: %v", err)
+	}
+	err = iter.Close()
+	if err != nil {
+		t.Fatalf("error closing iter: %v", err)
+	}
+	return ids, rowIDs
+}
+
+var historyPageTestCases = [...]struct {
+	// before and after are indexes into historyIDs, or -1 if they are not set.
+	before   int
+	after    int
+	limit    int
+	expected []string
+}{
+	0:  {before: -1, after: -1, expected: historyIDs},
+	1:  {before: -1, after: -1, limit: 2, expected: []string{"b", "f"}},
+	2:  {before: -1, after: -1, limit: 10, expected: historyIDs},
+	3:  {before: 4, after: -1, limit: 2, expected: []string{"d", "e"}},
+	4:  {before: 3, after: -1, limit: 2, expected: []string{"c", "d"}},
+	5:  {before: 2, after: -1, limit: 1, expected: []string{"c"}},
+	6:  {before: 1, after: -1, limit: 2, expected: []string{"a"}},
+	7:  {before: 0, after: -1, limit: 2},
+	8:  {before: 5, after: -1, expected: []string{"a", "c", "d", "e", "b"}},
+	9:  {before: -1, after: 0, limit: 1, expected: []string{"c"}},
+	10: {before: -1, after: 1, limit: 2, expected: []string{"d", "e"}},
+	11: {before: -1, after: 2, expected: []string{"e", "b", "f"}},
+	12: {before: -1, after: 3, limit: 1, expected: []string{"b"}},
+	13: {before: -1, after: 4, limit: 2, expected: []string{"f"}},
+	14: {before: -1, after: 5, limit: 2},
+}
+
+func TestHistoryPage(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	rowIDs := insertHistory(t, db)
+	for i, tc := range historyPageTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			page := storage.Page{Limit: tc.limit}
+			if tc.before >= 0 {
+				page.Before = rowIDs[tc.before]
+			}
+			if tc.after >= 0 {
+				page.After = rowIDs[tc.after]
+			}
+			ids, _ := queryHistory(t, db, page)
+			if !slices.Equal(ids, tc.expected) {
+				t.Errorf("wrong page: want=%v, got=%v", tc.expected, ids)
+			}
+		})
+	}
+}
+
+// TestHistoryPaginate checks that paging through the history in either
+// direction returns every message exactly once.
+func TestHistoryPaginate(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	insertHistory(t, db)
+	for _, limit := range []int{1, 2, 4} {
+		t.Run(strconv.Itoa(limit), func(t *testing.T) {
+			// Scroll back from the newest messages.
+			var back []string
+			page := storage.Page{Limit: limit}
+			for {
+				ids, rowIDs := queryHistory(t, db, page)
+				if len(ids) == 0 {
+					break
+				}
+				back = append(ids, back...)
+				page.Before = rowIDs[0]
+			}
+			if !slices.Equal(back, historyIDs) {
+				t.Errorf("wrong history paging backwards: want=%v, got=%v", historyIDs, back)
+			}
+
+			// Then forward again from the oldest message.
+			all, rowIDs := queryHistory(t, db, storage.Page{})
+			forward := all[:1]
+			page = storage.Page{After: rowIDs[0], Limit: limit}
+			for {
+				ids, rowIDs := queryHistory(t, db, page)
+				if len(ids) == 0 {
+					break
+				}
+				forward = append(forward, ids...)
+				page.After = rowIDs[len(rowIDs)-1]
+			}
+			if !slices.Equal(forward, historyIDs) {
+				t.Errorf("wrong history paging forwards: want=%v, got=%v", historyIDs, forward)
+			}
+		})
+	}
+}
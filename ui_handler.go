@@ -6,13 +6,18 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"slices"
+	"strings"
 	"time"
 
 	/* #nosec */
 	_ "crypto/sha1"
 	_ "crypto/sha256"
 
+	"github.com/rivo/tview"
+
 	"mellium.im/communique/internal/client"
 	clientevent "mellium.im/communique/internal/client/event"
 	"mellium.im/communique/internal/storage"
@@ -24,6 +29,7 @@ import (
 	"mellium.im/xmpp/disco/info"
 	"mellium.im/xmpp/history"
 	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
 	"mellium.im/xmpp/roster"
 	"mellium.im/xmpp/stanza"
 	"mellium.im/xmpp/upload"
@@ -31,7 +37,7 @@ import (
 
 // newUIHandler returns a handler for events that are emitted by the UI that
 // need to modify the client state.
-func newUIHandler(acct account, pane *ui.UI, db *storage.DB, c *client.Client, logger, debug *log.Logger) func(interface{}) {
+func newUIHandler(acct account, pane *ui.UI, db *storage.DB, c *client.Client, historySync *historySync, logger, debug *log.Logger) func(interface{}) {
 	p := pane.Printer()
 	return func(ev interface{}) {
 		switch e := ev.(type) {
@@ -178,13 +184,17 @@ func newUIHandler(acct account, pane *ui.UI, db *storage.DB, c *client.Client, l
 			}()
 		case event.ChatMessage:
 			go sendMessage(c, logger, db, pane, e)
+		case event.RetractMessage:
+			go sendRetraction(c, logger, db, pane, e)
+		case event.React:
+			go sendReaction(c, logger, db, pane, e)
 		case event.OpenChannel:
-			go openChannel(e, c, acct, debug, logger)
+			go openChannel(e, c, acct, pane, db, debug, logger)
 		case event.OpenChat:
-			go openChat(e, pane, db, logger)
+			go historySync.Prioritize(e.JID.Bare())
+			go openChat(e, c, pane, db, debug, logger)
 		case event.CloseChat:
-			history := pane.History()
-			history.SetText("")
+			pane.ClearHistory()
 		case event.Subscribe:
 			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 			defer cancel()
@@ -202,10 +212,82 @@ func newUIHandler(acct account, pane *ui.UI, db *storage.DB, c *client.Client, l
 			if err != nil {
 				logger.Print(p.Sprintf("error sending presence request to %s: %v", jid.JID(e), err))
 			}
+		case event.ChatState:
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), c.Timeout())
+				defer cancel()
+				if err := c.SendChatState(ctx, e.To, e.State); err != nil {
+					debug.Print(p.Sprintf("error sending chat state to %s: %v", e.To, err))
+				}
+			}()
+		case event.UpdateConversation:
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				err := db.UpsertConversation(ctx, storage.Conversation{
+					JID:        e.JID,
+					Name:       e.Name,
+					Room:       e.Room,
+					Notify:     e.Notify,
+					MutedUntil: e.MutedUntil,
+				})
+				if err != nil {
+					logger.Print(p.Sprintf("error saving conversation %s: %v", e.JID, err))
+				}
+			}()
+		case event.DeleteConversation:
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				if err := db.DeleteConversation(ctx, jid.JID(e)); err != nil {
+					logger.Print(p.Sprintf("error removing conversation %s: %v", jid.JID(e), err))
+				}
+			}()
+		case event.Search:
+			go searchHistory(e, pane, db, logger)
+		case event.JumpToMessage:
+			go jumpToMessage(e, c, pane, db, debug, logger)
+		case event.SetRole:
+			go setRole(c, logger, pane, e)
+		case event.SetAffiliation:
+			go setAffiliation(c, logger, pane, e)
+		case event.ChangeNick:
+			go changeNick(c, logger, pane, e)
+		case event.Invite:
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				err := c.Invite(ctx, e.Room, e.To, e.Reason)
+				if err != nil {
+					logger.Print(p.Sprintf("error inviting %s to %s: %v", e.To, e.Room, err))
+					pane.ShowError(p.Sprintf("Could not send the invitation: %v", err))
+				}
+			}()
+		case event.DeclineInvite:
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				err := c.DeclineInvite(ctx, e.Room, e.From)
+				if err != nil {
+					logger.Print(p.Sprintf("error declining invitation to %s: %v", e.Room, err))
+				}
+			}()
+		case event.CreateChannel:
+			go createChannel(jid.JID(e), c, pane, logger)
+		case event.ConfigureChannel:
+			go configureChannel(e, c, pane, logger)
+		case event.AcceptChannelDefaults:
+			go acceptChannelDefaults(jid.JID(e), c, pane, logger)
 		case event.PullToRefreshChat:
 			go pullToRefresh(e, c, pane, db, debug, logger)
 		case event.UploadFile:
 			go uploadFile(c, logger, debug, db, pane, e)
+		case event.PauseSync:
+			go historySync.Pause()
+		case event.ResumeSync:
+			go historySync.Resume()
+		case event.CancelSync:
+			go historySync.Cancel()
 		default:
 			debug.Print(p.Sprintf("unrecognized ui event: %T(%[1]q)", e))
 		}
@@ -219,23 +301,152 @@ func sendMessage(c *client.Client, logger *log.Logger, db *storage.DB, ui *ui.UI
 
 	p := c.Printer()
 
-	msg, err := c.SendMessage(ctx, clientevent.ChatMessage{
-		Message: message.Message,
-		Body:    message.Body,
-	})
+	outgoing := clientevent.ChatMessage{
+		Message:   message.Message,
+		Body:      message.Body,
+		ChatState: message.ChatState,
+		Sent:      true,
+	}
+	outgoing.Replace.ID = message.Replace
+	if message.ReplyID != "" {
+		outgoing = quoteReply(ctx, ui, db, outgoing, message.ReplyTo, message.ReplyID, c.LocalAddr(), logger)
+	}
+	msg, err := c.SendMessage(ctx, outgoing)
 	if err != nil {
 		logger.Print(p.Sprintf("error sending message: %v", err))
 	}
+	if msg.Replace.ID != "" {
+		ok, err := correctMessage(ctx, ui, db, msg, c.LocalAddr(), logger)
+		if err != nil {
+			logger.Print(p.Sprintf("error correcting message: %v", err))
+		}
+		if ok {
+			ui.SetLastSent(msg.With(), msg.Replace.ID, displayBody(msg))
+			return
+		}
+	}
 	if err = writeMessage(ui, msg, false); err != nil {
 		logger.Print(p.Sprintf("error saving sent message to history: %v", err))
 	}
 	if err = db.InsertMsg(ctx, msg.Account, msg, c.LocalAddr()); err != nil {
 		logger.Print(p.Sprintf("error writing message to database: %v", err))
 	}
+	if msg.Type != stanza.GroupChatMessage {
+		ui.SetLastSent(msg.With(), msg.ID, displayBody(msg))
+	}
 	// If we sent the message that wasn't automated (it has a body), assume
 	// we've read everything before it.
 	if message.Body != "" {
-		ui.Roster().MarkRead(message.To.Bare().String())
+		markRead(ctx, ui, db, msg.With(), logger)
+	}
+}
+
+// sendRetraction retracts a message that we sent earlier and replaces it with
+// a tombstone in the database and UI.
+func sendRetraction(c *client.Client, logger *log.Logger, db *storage.DB, ui *ui.UI, e event.RetractMessage) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	p := c.Printer()
+
+	msg, err := c.Retract(ctx, e.To, e.Type, e.ID)
+	if err != nil {
+		logger.Print(p.Sprintf("error retracting message: %v", err))
+	}
+	ok, err := retractMessage(ctx, ui, db, msg, c.LocalAddr(), logger)
+	switch {
+	case err != nil:
+		logger.Print(p.Sprintf("error retracting message: %v", err))
+	case !ok:
+		logger.Print(p.Sprintf("retracted message %q not found in history", e.ID))
+	}
+}
+
+// setRole changes the role of an occupant of a group chat and shows the error
+// if the group chat doesn't allow it.
+func setRole(c *client.Client, logger *log.Logger, ui *ui.UI, e event.SetRole) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	p := c.Printer()
+
+	err := c.SetRole(ctx, e.Room, e.Nick, e.Role, e.Reason)
+	if err != nil {
+		logger.Print(p.Sprintf("error setting role of %s in %s to %s: %v", e.Nick, e.Room, e.Role, err))
+		ui.ShowModerationError(err)
+	}
+}
+
+// setAffiliation changes the affiliation of a user to a group chat and shows
+// the error if the group chat doesn't allow it.
+func setAffiliation(c *client.Client, logger *log.Logger, ui *ui.UI, e event.SetAffiliation) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	p := c.Printer()
+
+	err := c.SetAffiliation(ctx, e.Room, e.JID, e.Affiliation, e.Reason)
+	if err != nil {
+		logger.Print(p.Sprintf("error setting affiliation of %s in %s to %s: %v", e.JID, e.Room, e.Affiliation, err))
+		ui.ShowModerationError(err)
+	}
+}
+
+// changeNick changes our nickname in a group chat and saves it to the bookmark
+// so that it is used the next time we join.
+func changeNick(c *client.Client, logger *log.Logger, ui *ui.UI, e event.ChangeNick) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	p := c.Printer()
+
+	err := c.ChangeNick(ctx, e.Room, e.Nick)
+	if err != nil {
+		logger.Print(p.Sprintf("error changing nickname in %s to %s: %v", e.Room, e.Nick, err))
+		ui.ShowError(p.Sprintf("Could not change your nickname: %v", err))
+		return
+	}
+	ui.ChangeBookmarkNick(e.Room, e.Nick)
+}
+
+// sendReaction adds or removes one of our reactions to a message, sends the new
+// set of reactions, and stores them in the database and UI.
+func sendReaction(c *client.Client, logger *log.Logger, db *storage.DB, ui *ui.UI, e event.React) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	p := c.Printer()
+
+	msg := clientevent.ChatMessage{
+		Message: stanza.Message{
+			To:   e.To,
+			Type: e.Type,
+		},
+	}
+	msg.Reactions.ID = e.ID
+	reactions, err := db.OwnReactions(ctx, msg, c.LocalAddr())
+	if err != nil {
+		logger.Print(p.Sprintf("error loading reactions: %v", err))
+		return
+	}
+	if idx := slices.Index(reactions, e.Reaction); idx >= 0 {
+		reactions = slices.Delete(reactions, idx, idx+1)
+	} else {
+		reactions = append(reactions, e.Reaction)
+	}
+
+	msg, err = c.React(ctx, e.To, e.Type, e.ID, reactions)
+	if err != nil {
+		logger.Print(p.Sprintf("error sending reactions: %v", err))
+	}
+	// Our reactions in group chats are stored when the group chat reflects them
+	// back to us.
+	if e.Type == stanza.GroupChatMessage {
+		return
+	}
+	_, err = reactMessage(ctx, ui, db, msg, c.LocalAddr(), logger)
+	if err != nil {
+		logger.Print(p.Sprintf("error saving reactions: %v", err))
 	}
 }
 
@@ -264,11 +475,12 @@ func uploadFile(c *client.Client, logger *log.Logger, debug *log.Logger, db *sto
 	sendMessage(c, logger, db, ui, ev.Message)
 }
 
-func openChat(e event.OpenChat, pane *ui.UI, db *storage.DB, logger *log.Logger) {
+func openChat(e event.OpenChat, c *client.Client, pane *ui.UI, db *storage.DB, debug, logger *log.Logger) {
+	pane.CancelEdit()
 	var firstUnread string
-	bare := e.JID.Bare().String()
-	item, ok := pane.Roster().GetItem(bare)
-	if ok {
+	if item, ok := pane.Roster().GetItem(e.JID.Bare().String()); ok {
+		firstUnread = item.FirstUnread()
+	} else if item, ok := pane.Conversations().GetItem(e.JID.String()); ok {
 		firstUnread = item.FirstUnread()
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -279,27 +491,130 @@ func openChat(e event.OpenChat, pane *ui.UI, db *storage.DB, logger *log.Logger)
 		return
 	}
 	pane.History().ScrollToEnd()
-	pane.Roster().MarkRead(bare)
-	pane.Conversations().MarkRead(bare)
+	markRead(ctx, pane, db, e.JID, logger)
 	pane.Redraw()
+	markDisplayed(ctx, c, db, e.JID, debug)
 }
 
-func openChannel(e event.OpenChannel, c *client.Client, acct account, debug, logger *log.Logger) {
+// markDisplayed sends a displayed chat marker (XEP-0333) for the last message
+// that we received from j if it hasn't been marked already.
+func markDisplayed(ctx context.Context, c *client.Client, db *storage.DB, j jid.JID, debug *log.Logger) {
+	p := c.Printer()
+	last, ok, err := db.LastMarkable(ctx, j)
+	if err != nil {
+		debug.Print(p.Sprintf("error finding last message from %s: %v", j, err))
+		return
+	}
+	if !ok || last.Displayed {
+		return
+	}
+	id := last.ID
+	if last.Type == stanza.GroupChatMessage {
+		id = ""
+		if len(last.SID) > 0 {
+			id = last.SID[0].ID
+		}
+	}
+	if id == "" {
+		return
+	}
+	err = c.MarkDisplayed(ctx, j, last.Type, id)
+	if err != nil {
+		debug.Print(p.Sprintf("error sending displayed marker to %s: %v", j, err))
+		return
+	}
+	marker := clientevent.ChatMessage{
+		Message: stanza.Message{
+			To:   j,
+			Type: last.Type,
+		},
+		Sent: true,
+	}
+	if j.Resourcepart() != "" {
+		marker.Private = &struct{}{}
+	}
+	marker.Marker.ID = id
+	_, err = db.MarkDisplayed(ctx, marker, c.LocalAddr())
+	if err != nil {
+		debug.Print(p.Sprintf("error marking messages from %s as displayed: %v", j, err))
+	}
+}
+
+func openChannel(e event.OpenChannel, c *client.Client, acct account, pane *ui.UI, db *storage.DB, debug, logger *log.Logger) {
+	// If the room keeps its own archive we don't ask it to send us recent
+	// history when we join and fetch it from the archive instead so that we can
+	// tell which messages we've already stored.
+	room := e.JID.Bare()
+	archived, extended := archiveFeatures(c, room, debug)
+	var opts []muc.Option
+	if archived {
+		opts = append(opts, muc.MaxHistory(0))
+	}
+	if e.Password != "" {
+		opts = append(opts, muc.Password(e.Password))
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	if acct.Name == "" {
-		acct.Name = c.LocalAddr().Localpart()
+	// Use the nickname from the bookmark if it has one, falling back to the one
+	// from the config file or our localpart.
+	nick := e.Nick
+	if nick == "" {
+		nick = acct.Name
+	}
+	if nick == "" {
+		nick = c.LocalAddr().Localpart()
 	}
 	p := c.Printer()
-	j, err := e.JID.WithResource(acct.Name)
+	j, err := e.JID.WithResource(nick)
 	if err != nil {
-		logger.Print(p.Sprintf("invalid nick %s in config: %v", acct.Name, err))
+		logger.Print(p.Sprintf("invalid nick %s: %v", nick, err))
 		return
 	}
 	debug.Print(p.Sprintf("joining room %v…", j))
-	err = c.JoinMUC(ctx, j)
+	err = c.JoinMUC(ctx, j, opts...)
 	if err != nil {
 		logger.Print(p.Sprintf("error joining room %s: %v", e.JID, err))
+		return
+	}
+	if archived {
+		fetchRoomHistory(room, extended, c, pane, db, debug, logger)
+	}
+}
+
+// fetchRoomHistory catches up on messages in a group chat from its archive
+// after the last message that we have stored.
+func fetchRoomHistory(room jid.JID, extended bool, c *client.Client, pane *ui.UI, db *storage.DB, debug, logger *log.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	p := c.Printer()
+	id, t, err := db.LastID(ctx, room)
+	if err != nil {
+		logger.Print(p.Sprintf("error fetching latest message info for %v from database: %v", room, err))
+		return
+	}
+	var q history.Query
+	switch {
+	case id != "" && extended:
+		q.AfterID = id
+	case !t.IsZero():
+		q.Start = t
+	default:
+		// We don't have any history yet, so bootstrap a limited amount of
+		// history from the archive.
+		_, _, _, screenHeight := pane.GetRect()
+		q = history.Query{
+			End:     time.Now(),
+			Limit:   uint64(2 * screenHeight), // #nosec G115
+			Reverse: true,
+			Last:    true,
+		}
+	}
+	debug.Print(p.Sprintf("fetching history for %v…", room))
+	_, err = history.Fetch(ctx, q, room, c.Session)
+	if err != nil {
+		debug.Print(p.Sprintf("error fetching history for %v: %v", room, err))
 	}
 }
 
@@ -308,8 +623,17 @@ func pullToRefresh(e event.PullToRefreshChat, c *client.Client, pane *ui.UI, db
 	defer cancel()
 
 	p := c.Printer()
-	// TODO: if mam:2#extended is supported, use archive ID
-	_, t, err := db.BeforeID(ctx, e.JID)
+	// Load older messages from the database if we have any before asking the
+	// server for more.
+	ok, err := loadOlder(ctx, pane, db, roster.Item(e), logger)
+	if err != nil {
+		logger.Print(p.Sprintf("error loading scrollback into pane for %v: %v", e.JID, err))
+		return
+	}
+	if ok {
+		return
+	}
+	id, t, err := db.BeforeID(ctx, e.JID)
 	if err != nil {
 		logger.Print(p.Sprintf("error fetching earliest message info for %v from database: %v", e, err))
 		return
@@ -318,22 +642,103 @@ func pullToRefresh(e event.PullToRefreshChat, c *client.Client, pane *ui.UI, db
 		debug.Print(p.Sprintf("no scrollback for %v", e.JID))
 		return
 	}
-	debug.Print(p.Sprintf("fetching scrollback before %v for %v…", t, e.JID))
+	// Group chats keep their own archive, everything else (including private
+	// messages with their occupants) is in ours.
+	archive, with := c.LocalAddr().Bare(), e.JID
+	if c.InMUC(e.JID) && e.JID.Resourcepart() == "" {
+		archive, with = e.JID.Bare(), jid.JID{}
+	}
 	_, _, _, screenHeight := pane.GetRect()
-	_, err = history.Fetch(ctx, history.Query{
-		With:    e.JID,
+	q := history.Query{
+		With:    with,
 		End:     t,
 		Limit:   uint64(2 * screenHeight), // #nosec G115
 		Reverse: true,
 		Last:    true,
-	}, c.Session.LocalAddr().Bare(), c.Session)
+	}
+	var extended bool
+	if id != "" {
+		_, extended = archiveFeatures(c, archive, debug)
+	}
+	if extended {
+		q.End = time.Time{}
+		q.BeforeID = id
+		debug.Print(p.Sprintf("fetching scrollback before %s for %v…", id, e.JID))
+	} else {
+		debug.Print(p.Sprintf("fetching scrollback before %v for %v…", t, e.JID))
+	}
+	_, err = history.Fetch(ctx, q, archive, c.Session)
 	if err != nil {
 		debug.Print(p.Sprintf("error fetching scrollback for %v: %v", e.JID, err))
 	}
-	if err := loadBuffer(ctx, pane, db, roster.Item(e), "", logger); err != nil {
+	if _, err := loadOlder(ctx, pane, db, roster.Item(e), logger); err != nil {
 		logger.Print(p.Sprintf("error loading scrollback into pane for %v: %v", e.JID, err))
+	}
+}
+
+// jumpToMessage opens a conversation and selects one of its messages, loading
+// older pages of the history until it is found.
+func jumpToMessage(e event.JumpToMessage, c *client.Client, pane *ui.UI, db *storage.DB, debug, logger *log.Logger) {
+	openChat(event.OpenChat(e.Item), c, pane, db, debug, logger)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for !pane.SelectMessage(e.ID) {
+		ok, err := loadOlder(ctx, pane, db, e.Item, logger)
+		if err != nil {
+			p := pane.Printer()
+			logger.Print(p.Sprintf("error loading scrollback into pane for %v: %v", e.JID, err))
+			return
+		}
+		if !ok {
+			return
+		}
+	}
+}
+
+// maxSearchResults is the maximum number of messages shown when searching the
+// history.
+const maxSearchResults = 100
+
+// matchReplacer highlights the matches in search result snippets.
+var matchReplacer = strings.NewReplacer(
+	storage.MatchStart, "[::r]",
+	storage.MatchEnd, "[::-]",
+	"\n", " ",
+)
+
+// searchHistory searches the stored history and shows the results.
+func searchHistory(e event.Search, pane *ui.UI, db *storage.DB, logger *log.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	p := pane.Printer()
+
+	var results []ui.SearchResult
+	iter := db.Search(ctx, e.Query, e.JID, maxSearchResults)
+	for iter.Next() {
+		cur := iter.Result()
+		r := ui.SearchResult{
+			JID:  cur.JID,
+			Room: cur.Type == stanza.GroupChatMessage,
+			ID:   cur.ID,
+		}
+		if r.Room {
+			r.ID = ""
+			if len(cur.SID) > 0 {
+				r.ID = cur.SID[0].ID
+			}
+		}
+		arrow := "←"
+		if cur.Sent {
+			arrow = "→"
+		}
+		sent := cur.Delay.Time.Local()
+		r.Label = tview.Escape(fmt.Sprintf("%s %s %s %s", sent.Format(time.DateOnly), pane.FormatTime(sent), arrow, cur.JID))
+		r.Snippet = matchReplacer.Replace(tview.Escape(cur.Snippet))
+		results = append(results, r)
+	}
+	if err := iter.Err(); err != nil {
+		logger.Print(p.Sprintf("error searching history for %q: %v", e.Query, err))
 		return
 	}
-	// TODO: scroll to an offset that keeps context so that we don't lose
-	// our position.
+	pane.ShowSearchResults(e.Query, results)
 }